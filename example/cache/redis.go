@@ -22,8 +22,8 @@ func main() {
 	payload := "19619c9e08f0ed4cc147e211efa8c3fb"
 	r, err := cache.SetEx(ctx, payload, 1, 20)
 	fmt.Println(r, err) // output: OK nil
-	ov := cache.Overdue(ctx, payload)
-	fmt.Println(ov)                             // output: true
+	ttl, err := cache.TTL(ctx, payload)
+	fmt.Println(ttl, err)                       // output: 20s <nil>
 	fmt.Println(cache.Set(ctx, payload, "105")) // output: OK nil
 	val, err := cache.Get(ctx, payload)
 	fmt.Println(string(val.([]uint8)), err) // output: 105 <nil>
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aivencs/kit/pkg/cache"
+	"github.com/aivencs/kit/pkg/limiter"
+)
+
+func main() {
+	ctx := context.WithValue(context.Background(), "trace", "ctx-limiter-001")
+	cache.InitCache("redis", cache.CacheOption{
+		Host:     "localhost:6379",
+		Auth:     true,
+		Password: "password",
+		DB:       1,
+	})
+	opt := limiter.LimiterOption{
+		Algorithm: limiter.TokenBucket,
+		Limit:     limiter.Limit{Rate: 2, Period: time.Second},
+	}
+	limiter.InitLimiter("redis", opt)
+	site := "example.com"
+	fmt.Println(limiter.AllowN(ctx, site, 2)) // output: true <nil>
+	fmt.Println(limiter.Allow(ctx, site))     // output: false <nil>
+	fmt.Println(limiter.Wait(ctx, site))      // output: <nil>
+}
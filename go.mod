@@ -3,12 +3,16 @@ module github.com/aivencs/kit
 go 1.17
 
 require (
+	github.com/FZambia/sentinel v1.1.0
 	github.com/RedisBloom/redisbloom-go v1.0.0
 	github.com/go-playground/validator v9.31.0+incompatible
 	github.com/go-resty/resty/v2 v2.7.0
 	github.com/gomodule/redigo v1.8.8
+	github.com/klauspost/compress v1.15.15
+	github.com/mna/redisc v1.3.2
 	github.com/spf13/viper v1.10.1
 	github.com/streadway/amqp v1.0.0
+	github.com/vmihailenco/msgpack/v5 v5.3.5
 	go.uber.org/zap v1.21.0
 )
 
@@ -58,6 +62,7 @@ require (
 	github.com/spf13/jwalterweatherman v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/subosito/gotenv v1.2.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	go.etcd.io/etcd/api/v3 v3.5.1 // indirect
 	go.etcd.io/etcd/client/pkg/v3 v3.5.1 // indirect
 	go.etcd.io/etcd/client/v2 v2.305.1 // indirect
@@ -0,0 +1,308 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/aivencs/kit/pkg/internal/redisconn"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/mna/redisc"
+)
+
+var ErrTxAborted = errors.New("cache: transaction aborted, a watched key changed")
+
+// BatchError reports the keys of a batch write that failed.
+type BatchError map[string]error
+
+func (e BatchError) Error() string {
+	keys := make([]string, 0, len(e))
+	for key := range e {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return fmt.Sprintf("cache: %d keys failed, %s: %v", len(e), keys[0], e[keys[0]])
+}
+
+// Batch queues commands to be sent together on one connection.
+type Batch struct {
+	cmds []batchCmd
+}
+
+type batchCmd struct {
+	name string
+	args []interface{}
+}
+
+// Send queues a command, its reply is found at the same index of the result.
+func (b *Batch) Send(cmd string, args ...interface{}) {
+	b.cmds = append(b.cmds, batchCmd{name: cmd, args: args})
+}
+
+// Reply is the outcome of one pipelined command. Err holds an error reply of
+// that command alone, connection failures abort the whole pipeline.
+type Reply struct {
+	Value interface{}
+	Err   error
+}
+
+// Pipeline sends the commands queued by fn in one round trip. Cluster
+// commands may live on different nodes, so they are sent one by one. Keys are
+// sent as is, Key returns their namespaced form.
+func (c *CacheBaseRedis) Pipeline(ctx context.Context, fn func(b *Batch)) ([]Reply, error) {
+	b := &Batch{}
+	fn(b)
+	if len(b.cmds) == 0 {
+		return nil, nil
+	}
+	if c.Cluster != nil {
+		return c.sequential(ctx, b)
+	}
+	r := c.get(ctx)
+	defer r.Close()
+	for _, cmd := range b.cmds {
+		if err := r.Send(cmd.name, cmd.args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]Reply, len(b.cmds))
+	for i := range b.cmds {
+		val, err := r.Receive()
+		if _, ok := err.(redigo.Error); err != nil && !ok {
+			return nil, err
+		}
+		replies[i] = Reply{Value: val, Err: err}
+	}
+	return replies, nil
+}
+
+func (c *CacheBaseRedis) sequential(ctx context.Context, b *Batch) ([]Reply, error) {
+	replies := make([]Reply, len(b.cmds))
+	for i, cmd := range b.cmds {
+		r := c.get(ctx, commandKeys(cmd)...)
+		val, err := r.Do(cmd.name, cmd.args...)
+		r.Close()
+		if _, ok := err.(redigo.Error); err != nil && !ok {
+			return nil, err
+		}
+		replies[i] = Reply{Value: val, Err: err}
+	}
+	return replies, nil
+}
+
+// Transaction watches the given keys, lets fn read them on conn and queue
+// commands, then runs the commands atomically with MULTI/EXEC. It fails
+// with ErrTxAborted if a watched key changed after WATCH, the caller may
+// retry it as a whole. An error of fn discards the transaction. On a
+// cluster every key must hash to the same slot. As with Pipeline, keys are
+// sent as is.
+func (c *CacheBaseRedis) Transaction(ctx context.Context, fn func(conn redigo.Conn, b *Batch) error, watch ...string) ([]Reply, error) {
+	var r, conn redigo.Conn
+	if c.Cluster != nil {
+		conn = c.Cluster.Get()
+		if len(watch) > 0 {
+			if err := redisc.BindConn(conn, watch...); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		r = redisconn.WithContext(conn, ctx, func() {})
+	} else {
+		r = c.get(ctx)
+	}
+	defer r.Close()
+	if len(watch) > 0 {
+		if _, err := r.Do("WATCH", redigo.Args{}.AddFlat(watch)...); err != nil {
+			return nil, err
+		}
+	}
+	b := &Batch{}
+	if err := fn(r, b); err != nil {
+		return nil, err
+	}
+	if len(b.cmds) == 0 {
+		return nil, nil
+	}
+	if conn != nil && len(watch) == 0 {
+		// fails when a read of fn bound the connection already
+		redisc.BindConn(conn, commandKeys(b.cmds[0])...)
+	}
+	r.Send("MULTI")
+	for _, cmd := range b.cmds {
+		r.Send(cmd.name, cmd.args...)
+	}
+	values, err := redigo.Values(r.Do("EXEC"))
+	if err == redigo.ErrNil {
+		return nil, ErrTxAborted
+	}
+	if err != nil {
+		return nil, err
+	}
+	replies := make([]Reply, len(values))
+	for i, val := range values {
+		if e, ok := val.(redigo.Error); ok {
+			replies[i] = Reply{Err: e}
+		} else {
+			replies[i] = Reply{Value: val}
+		}
+	}
+	return replies, nil
+}
+
+// commandKeys guesses the key of a command from its first argument.
+func commandKeys(cmd batchCmd) []string {
+	if len(cmd.args) == 0 {
+		return nil
+	}
+	return []string{string(formatValue(cmd.args[0]))}
+}
+
+func (c *CacheBaseRedis) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	keys = c.keys(keys)
+	if c.Cluster == nil {
+		r := c.get(ctx)
+		defer r.Close()
+		return redigo.Values(r.Do("MGET", redigo.Args{}.AddFlat(keys)...))
+	}
+	replies, err := c.Pipeline(ctx, func(b *Batch) {
+		for _, key := range keys {
+			b.Send("GET", key)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(replies))
+	for i, reply := range replies {
+		if reply.Err != nil {
+			return nil, reply.Err
+		}
+		values[i] = reply.Value
+	}
+	return values, nil
+}
+
+func (c *CacheBaseRedis) MSet(ctx context.Context, values map[string]interface{}) error {
+	if len(values) == 0 {
+		return nil
+	}
+	if c.Cluster == nil {
+		args := redigo.Args{}
+		for key, value := range values {
+			args = args.Add(c.Key(key), value)
+		}
+		r := c.get(ctx)
+		defer r.Close()
+		_, err := r.Do("MSET", args...)
+		return err
+	}
+	return c.batchWrite(ctx, values, func(b *Batch, key string, value interface{}) {
+		b.Send("SET", key, value)
+	})
+}
+
+func (c *CacheBaseRedis) MSetEx(ctx context.Context, values map[string]interface{}, sec int) error {
+	return c.batchWrite(ctx, values, func(b *Batch, key string, value interface{}) {
+		b.Send("SETEX", key, sec, value)
+	})
+}
+
+func (c *CacheBaseRedis) batchWrite(ctx context.Context, values map[string]interface{}, send func(b *Batch, key string, value interface{})) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	replies, err := c.Pipeline(ctx, func(b *Batch) {
+		for _, key := range keys {
+			send(b, c.Key(key), values[key])
+		}
+	})
+	if err != nil {
+		return err
+	}
+	failed := BatchError{}
+	for i, reply := range replies {
+		if reply.Err != nil {
+			failed[keys[i]] = reply.Err
+		}
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+func (c *CacheBaseRedis) DeleteMany(ctx context.Context, keys ...string) (int64, error) {
+	return c.deleteKeys(ctx, c.keys(keys))
+}
+
+// deleteKeys deletes stored keys, which are not namespaced again.
+func (c *CacheBaseRedis) deleteKeys(ctx context.Context, keys []string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	if c.Cluster == nil {
+		r := c.get(ctx)
+		defer r.Close()
+		return redigo.Int64(r.Do("DEL", redigo.Args{}.AddFlat(keys)...))
+	}
+	replies, err := c.Pipeline(ctx, func(b *Batch) {
+		for _, key := range keys {
+			b.Send("DEL", key)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, reply := range replies {
+		if reply.Err != nil {
+			return n, reply.Err
+		}
+		n += reply.Value.(int64)
+	}
+	return n, nil
+}
+
+/*
+batch helpers for caller
+*/
+
+func MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	return cache.MGet(ctx, keys...)
+}
+
+func MSet(ctx context.Context, values map[string]interface{}) error {
+	return cache.MSet(ctx, values)
+}
+
+func MSetEx(ctx context.Context, values map[string]interface{}, sec int) error {
+	return cache.MSetEx(ctx, values, sec)
+}
+
+func DeleteMany(ctx context.Context, keys ...string) (int64, error) {
+	return cache.DeleteMany(ctx, keys...)
+}
+
+func Pipeline(ctx context.Context, fn func(b *Batch)) ([]Reply, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return nil, err
+	}
+	return rc.Pipeline(ctx, fn)
+}
+
+func Transaction(ctx context.Context, fn func(conn redigo.Conn, b *Batch) error, watch ...string) ([]Reply, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return nil, err
+	}
+	return rc.Transaction(ctx, fn, watch...)
+}
@@ -0,0 +1,429 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = 10 * time.Second
+	defaultHalfOpenProbes   = 1
+)
+
+var (
+	ErrCircuitOpen = errors.New("cache: circuit breaker is open")
+)
+
+type BreakerState int
+
+const (
+	StateClosed BreakerState = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type BreakerOption struct {
+	// consecutive failures opening the circuit, InitCache only adds the
+	// breaker when set
+	FailureThreshold int
+	// how long the circuit stays open before probing, defaults to 10s
+	OpenTimeout time.Duration
+	// successful probes closing a half-open circuit, defaults to 1
+	HalfOpenProbes int
+	// serve from an in-memory cache, sized by the memory options, while the
+	// circuit is not closed
+	Fallback bool
+	// called after every state change
+	OnStateChange func(from, to BreakerState)
+}
+
+func (opt BreakerOption) enabled() bool {
+	return opt.FailureThreshold > 0
+}
+
+// BreakerCache stops calling its cache after consecutive failures and fails
+// fast with ErrCircuitOpen, or serves from the fallback, until a probe call
+// succeeds again. One probe runs at a time. Writes to the fallback are not
+// replayed, a memory fallback is cleared when the circuit closes.
+type BreakerCache struct {
+	Cache
+	fallback  Cache
+	opt       BreakerOption
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	successes int
+	openedAt  time.Time
+	probing   bool
+}
+
+// new breaker cache, fallback may be nil
+func NewBreakerCache(c Cache, fallback Cache, opt BreakerOption) Cache {
+	if opt.FailureThreshold <= 0 {
+		opt.FailureThreshold = defaultFailureThreshold
+	}
+	if opt.OpenTimeout <= 0 {
+		opt.OpenTimeout = defaultOpenTimeout
+	}
+	if opt.HalfOpenProbes <= 0 {
+		opt.HalfOpenProbes = defaultHalfOpenProbes
+	}
+	return &BreakerCache{Cache: c, fallback: fallback, opt: opt}
+}
+
+func (c *BreakerCache) State() BreakerState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// failure reports whether err tells the backend is unavailable. Missing keys
+// and redis error replies are the caller's concern.
+func failure(err error) bool {
+	var reply redigo.Error
+	return err != nil && !errors.Is(err, ErrNotFound) && !errors.As(err, &reply)
+}
+
+// inconclusive reports whether a call ended without telling anything about
+// the backend, canceled by its caller or not supported.
+func inconclusive(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, ErrUnsupported)
+}
+
+// route returns the cache serving a call and the func recording its result,
+// a nil cache when the call is rejected.
+func (c *BreakerCache) route() (Cache, func(error)) {
+	c.mu.Lock()
+	from := c.state
+	if c.state == StateOpen && time.Since(c.openedAt) >= c.opt.OpenTimeout {
+		c.state = StateHalfOpen
+		c.successes = 0
+	}
+	allowed, probe := c.state == StateClosed, false
+	if c.state == StateHalfOpen && !c.probing {
+		c.probing = true
+		allowed, probe = true, true
+	}
+	to := c.state
+	c.mu.Unlock()
+	c.notify(from, to)
+	if allowed {
+		return c.Cache, func(err error) { c.record(probe, err) }
+	}
+	return c.fallback, func(error) {}
+}
+
+func (c *BreakerCache) record(probe bool, err error) {
+	failed := failure(err)
+	c.mu.Lock()
+	from := c.state
+	if probe {
+		c.probing = false
+	}
+	switch {
+	case inconclusive(err):
+		// counts neither way, a probe leaves the circuit half-open for the
+		// next call
+	case probe && failed:
+		c.open()
+	case probe:
+		c.successes++
+		if c.successes >= c.opt.HalfOpenProbes {
+			c.state = StateClosed
+			c.failures = 0
+		}
+	case c.state != StateClosed:
+		// a call started before the circuit opened
+	case failed:
+		c.failures++
+		if c.failures >= c.opt.FailureThreshold {
+			c.open()
+		}
+	default:
+		c.failures = 0
+	}
+	to := c.state
+	c.mu.Unlock()
+	if to == StateClosed && from != StateClosed {
+		if m, ok := c.fallback.(*CacheBaseMemory); ok {
+			m.flush()
+		}
+	}
+	c.notify(from, to)
+}
+
+func (c *BreakerCache) open() {
+	c.state = StateOpen
+	c.openedAt = time.Now()
+	c.failures = 0
+}
+
+func (c *BreakerCache) notify(from, to BreakerState) {
+	if from != to && c.opt.OnStateChange != nil {
+		c.opt.OnStateChange(from, to)
+	}
+}
+
+func (c *BreakerCache) Get(ctx context.Context, key string) (interface{}, error) {
+	target, done := c.route()
+	if target == nil {
+		return nil, ErrCircuitOpen
+	}
+	val, err := target.Get(ctx, key)
+	done(err)
+	return val, err
+}
+
+func (c *BreakerCache) Set(ctx context.Context, key string, value interface{}) (interface{}, error) {
+	target, done := c.route()
+	if target == nil {
+		return nil, ErrCircuitOpen
+	}
+	res, err := target.Set(ctx, key, value)
+	done(err)
+	return res, err
+}
+
+// Overdue hides errors, it neither trips nor probes the circuit.
+func (c *BreakerCache) Overdue(ctx context.Context, key interface{}) bool {
+	if c.State() == StateClosed {
+		return c.Cache.Overdue(ctx, key)
+	}
+	if c.fallback == nil {
+		return false
+	}
+	return c.fallback.Overdue(ctx, key)
+}
+
+func (c *BreakerCache) SetEx(ctx context.Context, key string, value interface{}, sec int) (interface{}, error) {
+	target, done := c.route()
+	if target == nil {
+		return nil, ErrCircuitOpen
+	}
+	res, err := target.SetEx(ctx, key, value, sec)
+	done(err)
+	return res, err
+}
+
+func (c *BreakerCache) SetWithTags(ctx context.Context, key string, value interface{}, tags ...string) (interface{}, error) {
+	target, done := c.route()
+	if target == nil {
+		return nil, ErrCircuitOpen
+	}
+	res, err := target.SetWithTags(ctx, key, value, tags...)
+	done(err)
+	return res, err
+}
+
+func (c *BreakerCache) SetExWithTags(ctx context.Context, key string, value interface{}, sec int, tags ...string) (interface{}, error) {
+	target, done := c.route()
+	if target == nil {
+		return nil, ErrCircuitOpen
+	}
+	res, err := target.SetExWithTags(ctx, key, value, sec, tags...)
+	done(err)
+	return res, err
+}
+
+func (c *BreakerCache) InvalidateTags(ctx context.Context, tags ...string) (int64, error) {
+	target, done := c.route()
+	if target == nil {
+		return 0, ErrCircuitOpen
+	}
+	n, err := target.InvalidateTags(ctx, tags...)
+	done(err)
+	return n, err
+}
+
+func (c *BreakerCache) Delete(ctx context.Context, key string) (bool, error) {
+	target, done := c.route()
+	if target == nil {
+		return false, ErrCircuitOpen
+	}
+	ok, err := target.Delete(ctx, key)
+	done(err)
+	return ok, err
+}
+
+func (c *BreakerCache) Exists(ctx context.Context, key string) (bool, error) {
+	target, done := c.route()
+	if target == nil {
+		return false, ErrCircuitOpen
+	}
+	ok, err := target.Exists(ctx, key)
+	done(err)
+	return ok, err
+}
+
+func (c *BreakerCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	target, done := c.route()
+	if target == nil {
+		return 0, ErrCircuitOpen
+	}
+	ttl, err := target.TTL(ctx, key)
+	done(err)
+	return ttl, err
+}
+
+func (c *BreakerCache) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	target, done := c.route()
+	if target == nil {
+		return false, ErrCircuitOpen
+	}
+	ok, err := target.Expire(ctx, key, ttl)
+	done(err)
+	return ok, err
+}
+
+func (c *BreakerCache) ExpireAt(ctx context.Context, key string, at time.Time) (bool, error) {
+	target, done := c.route()
+	if target == nil {
+		return false, ErrCircuitOpen
+	}
+	ok, err := target.ExpireAt(ctx, key, at)
+	done(err)
+	return ok, err
+}
+
+func (c *BreakerCache) Persist(ctx context.Context, key string) (bool, error) {
+	target, done := c.route()
+	if target == nil {
+		return false, ErrCircuitOpen
+	}
+	ok, err := target.Persist(ctx, key)
+	done(err)
+	return ok, err
+}
+
+func (c *BreakerCache) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	target, done := c.route()
+	if target == nil {
+		return 0, ErrCircuitOpen
+	}
+	n, err := target.Incr(ctx, key, ttl)
+	done(err)
+	return n, err
+}
+
+func (c *BreakerCache) IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	target, done := c.route()
+	if target == nil {
+		return 0, ErrCircuitOpen
+	}
+	v, err := target.IncrBy(ctx, key, n, ttl)
+	done(err)
+	return v, err
+}
+
+func (c *BreakerCache) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	target, done := c.route()
+	if target == nil {
+		return false, ErrCircuitOpen
+	}
+	ok, err := target.SetNX(ctx, key, value, ttl)
+	done(err)
+	return ok, err
+}
+
+func (c *BreakerCache) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	target, done := c.route()
+	if target == nil {
+		return nil, ErrCircuitOpen
+	}
+	values, err := target.MGet(ctx, keys...)
+	done(err)
+	return values, err
+}
+
+func (c *BreakerCache) MSet(ctx context.Context, values map[string]interface{}) error {
+	target, done := c.route()
+	if target == nil {
+		return ErrCircuitOpen
+	}
+	err := target.MSet(ctx, values)
+	done(err)
+	return err
+}
+
+func (c *BreakerCache) MSetEx(ctx context.Context, values map[string]interface{}, sec int) error {
+	target, done := c.route()
+	if target == nil {
+		return ErrCircuitOpen
+	}
+	err := target.MSetEx(ctx, values, sec)
+	done(err)
+	return err
+}
+
+func (c *BreakerCache) DeleteMany(ctx context.Context, keys ...string) (int64, error) {
+	target, done := c.route()
+	if target == nil {
+		return 0, ErrCircuitOpen
+	}
+	n, err := target.DeleteMany(ctx, keys...)
+	done(err)
+	return n, err
+}
+
+// Scan fetches lazily, like Overdue it neither trips nor probes the circuit.
+func (c *BreakerCache) Scan(ctx context.Context, pattern string) *KeyIterator {
+	if c.State() == StateClosed {
+		return c.Cache.Scan(ctx, pattern)
+	}
+	if c.fallback == nil {
+		return &KeyIterator{err: ErrCircuitOpen}
+	}
+	return c.fallback.Scan(ctx, pattern)
+}
+
+func (c *BreakerCache) Flush(ctx context.Context, namespace string) (int64, error) {
+	target, done := c.route()
+	if target == nil {
+		return 0, ErrCircuitOpen
+	}
+	n, err := target.Flush(ctx, namespace)
+	done(err)
+	return n, err
+}
+
+/*
+breaker helpers for caller
+*/
+
+// CircuitState returns the state of the package cache breaker, closed when
+// there is none.
+func CircuitState() BreakerState {
+	if b := breakerOf(cache); b != nil {
+		return b.State()
+	}
+	return StateClosed
+}
+
+func breakerOf(c Cache) *BreakerCache {
+	switch v := c.(type) {
+	case *BreakerCache:
+		return v
+	case *MeteredCache:
+		return breakerOf(v.Cache)
+	case *TransformCache:
+		return breakerOf(v.Cache)
+	}
+	return nil
+}
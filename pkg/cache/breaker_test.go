@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errDown = &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+
+// flakyCache fails its reads and writes with err while it is set, and
+// blocks them while block is open.
+type flakyCache struct {
+	Cache
+	mu    sync.Mutex
+	err   error
+	block chan struct{}
+}
+
+func (c *flakyCache) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+func (c *flakyCache) result() error {
+	c.mu.Lock()
+	block, err := c.block, c.err
+	c.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+	return err
+}
+
+func (c *flakyCache) Get(ctx context.Context, key string) (interface{}, error) {
+	if err := c.result(); err != nil {
+		return nil, err
+	}
+	return c.Cache.Get(ctx, key)
+}
+
+func (c *flakyCache) Set(ctx context.Context, key string, value interface{}) (interface{}, error) {
+	if err := c.result(); err != nil {
+		return nil, err
+	}
+	return c.Cache.Set(ctx, key, value)
+}
+
+type transitions struct {
+	mu  sync.Mutex
+	got []string
+}
+
+func (tr *transitions) record(from, to BreakerState) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.got = append(tr.got, from.String()+">"+to.String())
+}
+
+func (tr *transitions) String() string {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	s := ""
+	for i, t := range tr.got {
+		if i > 0 {
+			s += " "
+		}
+		s += t
+	}
+	return s
+}
+
+func newTestBreaker(opt BreakerOption, fallback Cache) (*BreakerCache, *flakyCache) {
+	inner := &flakyCache{Cache: newTestMemory(CacheOption{})}
+	return NewBreakerCache(inner, fallback, opt).(*BreakerCache), inner
+}
+
+func TestBreakerThreshold(t *testing.T) {
+	ctx := context.Background()
+	c, inner := newTestBreaker(BreakerOption{FailureThreshold: 3, OpenTimeout: time.Hour}, nil)
+	inner.fail(errDown)
+	c.Get(ctx, "k")
+	c.Get(ctx, "k")
+	// a success resets the count
+	inner.fail(nil)
+	c.Get(ctx, "k")
+	inner.fail(errDown)
+	c.Get(ctx, "k")
+	c.Get(ctx, "k")
+	if c.State() != StateClosed {
+		t.Fatalf("state = %v after 2 consecutive failures, want closed", c.State())
+	}
+	// a missing key is an answer of a working backend
+	inner.fail(ErrNotFound)
+	c.Get(ctx, "k")
+	inner.fail(errDown)
+	c.Get(ctx, "k")
+	c.Get(ctx, "k")
+	// a canceled call tells nothing and keeps the count
+	inner.fail(context.Canceled)
+	c.Get(ctx, "k")
+	inner.fail(errDown)
+	c.Get(ctx, "k")
+	if c.State() != StateOpen {
+		t.Fatalf("state = %v after 3 failures, want open", c.State())
+	}
+	inner.fail(nil)
+	if _, err := c.Get(ctx, "k"); err != ErrCircuitOpen {
+		t.Errorf("Get on an open circuit = %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	ctx := context.Background()
+	tr := &transitions{}
+	c, inner := newTestBreaker(BreakerOption{FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond, OnStateChange: tr.record}, nil)
+	inner.fail(errDown)
+	c.Get(ctx, "k")
+	time.Sleep(15 * time.Millisecond)
+	// a failed probe opens the circuit again
+	c.Get(ctx, "k")
+	if c.State() != StateOpen {
+		t.Fatalf("state = %v after a failed probe, want open", c.State())
+	}
+	time.Sleep(15 * time.Millisecond)
+	// a canceled probe proves nothing
+	inner.fail(context.Canceled)
+	c.Get(ctx, "k")
+	if c.State() != StateHalfOpen {
+		t.Fatalf("state = %v after a canceled probe, want half-open", c.State())
+	}
+	// one probe at a time, the other calls are rejected meanwhile
+	inner.fail(nil)
+	inner.block = make(chan struct{})
+	probed := make(chan error)
+	go func() {
+		_, err := c.Get(ctx, "k")
+		probed <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	inner.mu.Lock()
+	block := inner.block
+	inner.block = nil
+	inner.mu.Unlock()
+	if _, err := c.Get(ctx, "k"); err != ErrCircuitOpen {
+		t.Errorf("Get during a probe = %v, want ErrCircuitOpen", err)
+	}
+	close(block)
+	if err := <-probed; err != nil {
+		t.Fatal(err)
+	}
+	if c.State() != StateClosed {
+		t.Fatalf("state = %v after a probe succeeded, want closed", c.State())
+	}
+	want := "closed>open open>half-open half-open>open open>half-open half-open>closed"
+	if got := tr.String(); got != want {
+		t.Errorf("transitions = %s, want %s", got, want)
+	}
+}
+
+func TestBreakerHalfOpenProbes(t *testing.T) {
+	ctx := context.Background()
+	c, inner := newTestBreaker(BreakerOption{FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond, HalfOpenProbes: 2}, nil)
+	inner.fail(errDown)
+	c.Get(ctx, "k")
+	time.Sleep(15 * time.Millisecond)
+	inner.fail(nil)
+	c.Get(ctx, "k")
+	if c.State() != StateHalfOpen {
+		t.Fatalf("state = %v after 1 of 2 probes, want half-open", c.State())
+	}
+	c.Get(ctx, "k")
+	if c.State() != StateClosed {
+		t.Fatalf("state = %v after 2 probes, want closed", c.State())
+	}
+}
+
+func TestBreakerFallback(t *testing.T) {
+	ctx := context.Background()
+	fallback := newTestMemory(CacheOption{})
+	c, inner := newTestBreaker(BreakerOption{FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond}, fallback)
+	inner.Cache.Set(ctx, "k", "remote")
+	inner.fail(errDown)
+	c.Get(ctx, "k")
+	// the open circuit routes to the fallback
+	if _, err := c.Set(ctx, "k", "local"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Get(ctx, "k"); string(v.([]byte)) != "local" {
+		t.Errorf("Get = %s, want the fallback value", v)
+	}
+	if v, _ := inner.Cache.Get(ctx, "k"); string(v.([]byte)) != "remote" {
+		t.Errorf("the write reached the open backend, %s", v)
+	}
+	time.Sleep(15 * time.Millisecond)
+	inner.fail(nil)
+	if v, _ := c.Get(ctx, "k"); string(v.([]byte)) != "remote" {
+		t.Errorf("probe Get = %s, want the backend value", v)
+	}
+	// the fallback is cleared once the circuit closes
+	if v, _ := fallback.Get(ctx, "k"); v != nil {
+		t.Errorf("fallback kept %s", v)
+	}
+}
@@ -0,0 +1,342 @@
+package cache
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+// Z is a member of a sorted set.
+type Z struct {
+	Score  float64
+	Member string
+}
+
+/*
+hash
+*/
+
+// HGet returns the value of field, nil if the field or key does not exist.
+func (c *CacheBaseRedis) HGet(ctx context.Context, key, field string) (interface{}, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return r.Do("HGET", key, field)
+}
+
+func (c *CacheBaseRedis) HSet(ctx context.Context, key string, values map[string]interface{}) (int64, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return redigo.Int64(r.Do("HSET", redigo.Args{key}.AddFlat(values)...))
+}
+
+func (c *CacheBaseRedis) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return redigo.StringMap(r.Do("HGETALL", key))
+}
+
+func (c *CacheBaseRedis) HIncrBy(ctx context.Context, key, field string, n int64) (int64, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return redigo.Int64(r.Do("HINCRBY", key, field, n))
+}
+
+func (c *CacheBaseRedis) HDel(ctx context.Context, key string, fields ...string) (int64, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return redigo.Int64(r.Do("HDEL", redigo.Args{key}.AddFlat(fields)...))
+}
+
+/*
+list
+*/
+
+func (c *CacheBaseRedis) LPush(ctx context.Context, key string, values ...interface{}) (int64, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return redigo.Int64(r.Do("LPUSH", redigo.Args{key}.Add(values...)...))
+}
+
+// RPop returns the last element of the list, nil if it is empty.
+func (c *CacheBaseRedis) RPop(ctx context.Context, key string) (interface{}, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return r.Do("RPOP", key)
+}
+
+// BRPop waits for an element on the first non-empty list of keys until ctx
+// is done, or the default command timeout passes when ctx has no deadline.
+// It returns the list the element was popped from.
+func (c *CacheBaseRedis) BRPop(ctx context.Context, keys ...string) (string, interface{}, error) {
+	keys = c.keys(keys)
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if _, ok := ctx.Deadline(); !ok && c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	// zero blocks on the server until ctx interrupts the command
+	var block int64
+	if deadline, ok := ctx.Deadline(); ok {
+		block = int64(math.Max(1, math.Floor(time.Until(deadline).Seconds())))
+	}
+	r := c.get(ctx, keys...)
+	defer r.Close()
+	res, err := redigo.Values(r.Do("BRPOP", redigo.Args{}.AddFlat(keys).Add(block)...))
+	if err == redigo.ErrNil {
+		return "", nil, wrapError(context.DeadlineExceeded)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	key, err := redigo.String(res[0], nil)
+	return strings.TrimPrefix(key, c.Key("")), res[1], err
+}
+
+func (c *CacheBaseRedis) LLen(ctx context.Context, key string) (int64, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return redigo.Int64(r.Do("LLEN", key))
+}
+
+/*
+set
+*/
+
+func (c *CacheBaseRedis) SAdd(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return redigo.Int64(r.Do("SADD", redigo.Args{key}.Add(members...)...))
+}
+
+func (c *CacheBaseRedis) SIsMember(ctx context.Context, key string, member interface{}) (bool, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return redigo.Bool(r.Do("SISMEMBER", key, member))
+}
+
+func (c *CacheBaseRedis) SMembers(ctx context.Context, key string) ([]string, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return redigo.Strings(r.Do("SMEMBERS", key))
+}
+
+func (c *CacheBaseRedis) SRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return redigo.Int64(r.Do("SREM", redigo.Args{key}.Add(members...)...))
+}
+
+/*
+sorted set
+*/
+
+func (c *CacheBaseRedis) ZAdd(ctx context.Context, key string, members ...Z) (int64, error) {
+	key = c.Key(key)
+	args := redigo.Args{key}
+	for _, m := range members {
+		args = args.Add(m.Score, m.Member)
+	}
+	r := c.get(ctx, key)
+	defer r.Close()
+	return redigo.Int64(r.Do("ZADD", args...))
+}
+
+// ZRangeByScore returns the members scored within min and max, which accept
+// the redis syntax such as "-inf" or "(10" for exclusive bounds.
+func (c *CacheBaseRedis) ZRangeByScore(ctx context.Context, key, min, max string) ([]Z, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return zs(r.Do("ZRANGEBYSCORE", key, min, max, "WITHSCORES"))
+}
+
+// ZPopMin removes and returns up to count members with the lowest scores.
+func (c *CacheBaseRedis) ZPopMin(ctx context.Context, key string, count int64) ([]Z, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return zs(r.Do("ZPOPMIN", key, count))
+}
+
+func (c *CacheBaseRedis) ZRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return redigo.Int64(r.Do("ZREM", redigo.Args{key}.Add(members...)...))
+}
+
+// zs converts a member, score flat reply to []Z.
+func zs(reply interface{}, err error) ([]Z, error) {
+	values, err := redigo.Strings(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]Z, 0, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		score, err := strconv.ParseFloat(values[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, Z{Score: score, Member: values[i]})
+	}
+	return members, nil
+}
+
+/*
+collection helpers for caller
+*/
+
+func HGet(ctx context.Context, key, field string) (interface{}, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return nil, err
+	}
+	return rc.HGet(ctx, key, field)
+}
+
+func HSet(ctx context.Context, key string, values map[string]interface{}) (int64, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return 0, err
+	}
+	return rc.HSet(ctx, key, values)
+}
+
+func HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return nil, err
+	}
+	return rc.HGetAll(ctx, key)
+}
+
+func HIncrBy(ctx context.Context, key, field string, n int64) (int64, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return 0, err
+	}
+	return rc.HIncrBy(ctx, key, field, n)
+}
+
+func HDel(ctx context.Context, key string, fields ...string) (int64, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return 0, err
+	}
+	return rc.HDel(ctx, key, fields...)
+}
+
+func LPush(ctx context.Context, key string, values ...interface{}) (int64, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return 0, err
+	}
+	return rc.LPush(ctx, key, values...)
+}
+
+func RPop(ctx context.Context, key string) (interface{}, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return nil, err
+	}
+	return rc.RPop(ctx, key)
+}
+
+func BRPop(ctx context.Context, keys ...string) (string, interface{}, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return "", nil, err
+	}
+	return rc.BRPop(ctx, keys...)
+}
+
+func LLen(ctx context.Context, key string) (int64, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return 0, err
+	}
+	return rc.LLen(ctx, key)
+}
+
+func SAdd(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return 0, err
+	}
+	return rc.SAdd(ctx, key, members...)
+}
+
+func SIsMember(ctx context.Context, key string, member interface{}) (bool, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return false, err
+	}
+	return rc.SIsMember(ctx, key, member)
+}
+
+func SMembers(ctx context.Context, key string) ([]string, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return nil, err
+	}
+	return rc.SMembers(ctx, key)
+}
+
+func SRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return 0, err
+	}
+	return rc.SRem(ctx, key, members...)
+}
+
+func ZAdd(ctx context.Context, key string, members ...Z) (int64, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return 0, err
+	}
+	return rc.ZAdd(ctx, key, members...)
+}
+
+func ZRangeByScore(ctx context.Context, key, min, max string) ([]Z, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return nil, err
+	}
+	return rc.ZRangeByScore(ctx, key, min, max)
+}
+
+func ZPopMin(ctx context.Context, key string, count int64) ([]Z, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return nil, err
+	}
+	return rc.ZPopMin(ctx, key, count)
+}
+
+func ZRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return 0, err
+	}
+	return rc.ZRem(ctx, key, members...)
+}
@@ -0,0 +1,13 @@
+package cache
+
+import (
+	"github.com/aivencs/kit/pkg/internal/redisconn"
+)
+
+// ErrTimeout marks operations bounded by ctx or CommandTimeout that ran out
+// of time, it is shared with the filter package.
+var ErrTimeout = redisconn.ErrTimeout
+
+func wrapError(err error) error {
+	return redisconn.WrapError(err)
+}
@@ -0,0 +1,272 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aivencs/kit/pkg/internal/redisconn"
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+type EventType string
+
+const (
+	EventExpired EventType = "expired"
+	EventEvicted EventType = "evicted"
+	EventSet     EventType = "set"
+	EventDel     EventType = "del"
+
+	notifyConfig = "notify-keyspace-events"
+)
+
+var (
+	ErrNotificationsDisabled = errors.New("cache: keyspace notifications are not enabled for the requested events")
+
+	// notify-keyspace-events flag of each event, K selects keyspace channels
+	eventFlags = map[EventType]byte{
+		EventExpired: 'x',
+		EventEvicted: 'e',
+		EventSet:     '$',
+		EventDel:     'g',
+	}
+)
+
+// Event is a change of a key, Key has no namespace.
+type Event struct {
+	Type EventType
+	Key  string
+}
+
+type EventHandler func(Event)
+
+type EventOption struct {
+	// glob pattern of the keys in the namespace, defaults to every key
+	Pattern string
+	// defaults to every EventType
+	Types []EventType
+	// enable the missing notify-keyspace-events flags with CONFIG SET,
+	// otherwise Subscribe fails when they are missing
+	Configure bool
+}
+
+// Subscription delivers events until it is closed, reconnecting whenever
+// the connection breaks. Events raised while disconnected are lost.
+type Subscription struct {
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// Close stops the subscription and waits for the handler to return.
+func (s *Subscription) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+	s.wg.Wait()
+	return nil
+}
+
+// Subscribe calls handler for the keyspace events of opt, one event at a
+// time. On a cluster every master is subscribed, as events stay on the node
+// owning the key. Settings that cannot be read, e.g. when CONFIG is disabled
+// by a managed service, are assumed to be right.
+func (c *CacheBaseRedis) Subscribe(ctx context.Context, opt EventOption, handler EventHandler) (*Subscription, error) {
+	types := opt.Types
+	if len(types) == 0 {
+		types = []EventType{EventExpired, EventEvicted, EventSet, EventDel}
+	}
+	want := make(map[string]bool, len(types))
+	flags := "K"
+	for _, t := range types {
+		flag, ok := eventFlags[t]
+		if !ok {
+			return nil, fmt.Errorf("cache: unknown event type %q", t)
+		}
+		want[string(t)] = true
+		flags += string(flag)
+	}
+	addrs := []string{""}
+	if c.Cluster != nil {
+		addrs = nil
+		err := c.Cluster.EachNode(false, func(addr string, _ redigo.Conn) error {
+			addrs = append(addrs, addr)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, addr := range addrs {
+		if err := c.notifications(ctx, addr, flags, opt.Configure); err != nil {
+			return nil, err
+		}
+	}
+	pattern := opt.Pattern
+	if pattern == "" {
+		pattern = "*"
+	}
+	prefix := fmt.Sprintf("__keyspace@%d__:%s", c.db, c.Key(""))
+	channel := fmt.Sprintf("__keyspace@%d__:%s", c.db, c.Key(pattern))
+	sub := &Subscription{stop: make(chan struct{})}
+	// events of every node go through one handler at a time
+	var mu sync.Mutex
+	deliver := func(msg redigo.Message) {
+		if !want[string(msg.Data)] {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		handler(Event{Type: EventType(msg.Data), Key: strings.TrimPrefix(msg.Channel, prefix)})
+	}
+	for _, addr := range addrs {
+		sub.wg.Add(1)
+		go func(addr string) {
+			defer sub.wg.Done()
+			for {
+				c.listenEvents(addr, channel, sub.stop, deliver)
+				select {
+				case <-sub.stop:
+					return
+				case <-time.After(reconnectDelay):
+				}
+			}
+		}(addr)
+	}
+	return sub, nil
+}
+
+// Events is Subscribe delivering to a channel of size buffer, closed with
+// the subscription. A full channel holds the next events back.
+func (c *CacheBaseRedis) Events(ctx context.Context, opt EventOption, buffer int) (<-chan Event, *Subscription, error) {
+	events := make(chan Event, buffer)
+	stop := make(chan struct{})
+	sub, err := c.Subscribe(ctx, opt, func(e Event) {
+		select {
+		case events <- e:
+		case <-stop:
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	go func() {
+		<-sub.stop
+		close(stop)
+		sub.wg.Wait()
+		close(events)
+	}()
+	return events, sub, nil
+}
+
+// notifications checks, and with configure enables, the flags on the node
+// addr, any node when empty.
+func (c *CacheBaseRedis) notifications(ctx context.Context, addr, flags string, configure bool) error {
+	conn, err := c.nodeConn(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	values, err := redigo.Strings(conn.Do("CONFIG", "GET", notifyConfig))
+	if err != nil || len(values) != 2 {
+		return nil
+	}
+	current := values[1]
+	// A is an alias of the classes of every data type
+	expanded := strings.ReplaceAll(current, "A", "g$lshzxet")
+	missing := ""
+	for _, flag := range flags {
+		if !strings.ContainsRune(expanded, flag) {
+			missing += string(flag)
+		}
+	}
+	if missing == "" {
+		return nil
+	}
+	if !configure {
+		return ErrNotificationsDisabled
+	}
+	_, err = conn.Do("CONFIG", "SET", notifyConfig, current+missing)
+	return err
+}
+
+// nodeConn dials addr on a cluster, or borrows a pool connection.
+func (c *CacheBaseRedis) nodeConn(ctx context.Context, addr string) (redigo.Conn, error) {
+	if c.Cluster == nil {
+		return c.get(ctx), nil
+	}
+	conn, err := redigo.DialContext(ctx, "tcp", addr, c.Cluster.DialOptions...)
+	if err != nil {
+		return nil, err
+	}
+	// the cluster dial options have no read timeout, ctx bounds the commands
+	return redisconn.WithContext(conn, ctx, func() {}), nil
+}
+
+func (c *CacheBaseRedis) listenEvents(addr, channel string, stop chan struct{}, deliver func(redigo.Message)) {
+	var conn redigo.Conn
+	var err error
+	if c.Cluster == nil {
+		conn, err = c.dedicated()
+	} else {
+		conn, err = redigo.Dial("tcp", addr, c.Cluster.DialOptions...)
+	}
+	if err != nil {
+		return
+	}
+	psc := redigo.PubSubConn{Conn: conn}
+	defer psc.Close()
+	if err := psc.PSubscribe(channel); err != nil {
+		return
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	// the unsubscribe must not write while psc is being closed
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+	go func() {
+		defer close(stopped)
+		select {
+		case <-stop:
+			psc.PUnsubscribe()
+		case <-done:
+		}
+	}()
+	for {
+		switch v := psc.Receive().(type) {
+		case redigo.Message:
+			deliver(v)
+		case redigo.Subscription:
+			if v.Count == 0 {
+				return
+			}
+		case error:
+			return
+		}
+	}
+}
+
+/*
+event helpers for caller
+*/
+
+func Subscribe(ctx context.Context, opt EventOption, handler EventHandler) (*Subscription, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return nil, err
+	}
+	return rc.Subscribe(ctx, opt, handler)
+}
+
+func Events(ctx context.Context, opt EventOption, buffer int) (<-chan Event, *Subscription, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return nil, nil, err
+	}
+	return rc.Events(ctx, opt, buffer)
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+// NoExpiry is the ttl of a key that exists without an expiry.
+const NoExpiry time.Duration = -1
+
+var incrScript = redigo.NewScript(1, `
+local n = redis.call("INCRBY", KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 and redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return n`)
+
+func (c *CacheBaseRedis) Delete(ctx context.Context, key string) (bool, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return redigo.Bool(r.Do("DEL", key))
+}
+
+func (c *CacheBaseRedis) Exists(ctx context.Context, key string) (bool, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return redigo.Bool(r.Do("EXISTS", key))
+}
+
+// TTL returns the remaining time to live of key, NoExpiry if it has none and
+// ErrNotFound if it does not exist.
+func (c *CacheBaseRedis) TTL(ctx context.Context, key string) (time.Duration, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	ms, err := redigo.Int64(r.Do("PTTL", key))
+	if err != nil {
+		return 0, err
+	}
+	switch ms {
+	case -2:
+		return 0, ErrNotFound
+	case -1:
+		return NoExpiry, nil
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func (c *CacheBaseRedis) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return redigo.Bool(r.Do("PEXPIRE", key, ttl.Milliseconds()))
+}
+
+func (c *CacheBaseRedis) ExpireAt(ctx context.Context, key string, at time.Time) (bool, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return redigo.Bool(r.Do("PEXPIREAT", key, at.UnixMilli()))
+}
+
+func (c *CacheBaseRedis) Persist(ctx context.Context, key string) (bool, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return redigo.Bool(r.Do("PERSIST", key))
+}
+
+func (c *CacheBaseRedis) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return c.IncrBy(ctx, key, 1, ttl)
+}
+
+// IncrBy adds n to the integer at key. A positive ttl is applied when the
+// counter has no expiry yet, so a window starts with its first increment.
+func (c *CacheBaseRedis) IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	return redigo.Int64(incrScript.Do(r, key, n, ttl.Milliseconds()))
+}
+
+// SetNX sets key only if it does not exist, a positive ttl expires it.
+func (c *CacheBaseRedis) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	key = c.Key(key)
+	r := c.get(ctx, key)
+	defer r.Close()
+	args := redigo.Args{key, value, "NX"}
+	if ttl > 0 {
+		args = args.Add("PX", ttl.Milliseconds())
+	}
+	_, err := redigo.String(r.Do("SET", args...))
+	if err == redigo.ErrNil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+/*
+key helpers for caller
+*/
+
+func Delete(ctx context.Context, key string) (bool, error) {
+	return cache.Delete(ctx, key)
+}
+
+func Exists(ctx context.Context, key string) (bool, error) {
+	return cache.Exists(ctx, key)
+}
+
+func TTL(ctx context.Context, key string) (time.Duration, error) {
+	return cache.TTL(ctx, key)
+}
+
+func Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return cache.Expire(ctx, key, ttl)
+}
+
+func ExpireAt(ctx context.Context, key string, at time.Time) (bool, error) {
+	return cache.ExpireAt(ctx, key, at)
+}
+
+func Persist(ctx context.Context, key string) (bool, error) {
+	return cache.Persist(ctx, key)
+}
+
+func Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return cache.Incr(ctx, key, ttl)
+}
+
+func IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	return cache.IncrBy(ctx, key, n, ttl)
+}
+
+func SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	return cache.SetNX(ctx, key, value, ttl)
+}
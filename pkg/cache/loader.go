@@ -0,0 +1,458 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLoadLockTTL    = 5 * time.Second
+	defaultRefreshTimeout = 30 * time.Second
+	defaultLoadTimeout    = 30 * time.Second
+	defaultRefreshBackoff = 5 * time.Second
+	loadPollInterval      = 50 * time.Millisecond
+	envelopeHeader        = 17
+	envelopeValue         = 'v'
+	envelopeNegative      = 'n'
+)
+
+var (
+	loader *CacheLoader
+
+	errLoadExited = errors.New("cache: loader exited without a result")
+)
+
+// Loader produces the value of a key on a cache miss. Returning ErrNotFound
+// lets the result be cached negatively.
+type Loader func(ctx context.Context) (interface{}, error)
+
+type LoadOption struct {
+	// cache ErrNotFound from the loader for this long, zero disables
+	NegativeTTL time.Duration
+	// serialize loads across instances with a short redis lock
+	Lock    bool
+	LockTTL time.Duration
+	// probabilistic early refresh factor, 1 is a good start, zero disables
+	Beta float64
+	// bound of a background refresh of GetOrRefresh
+	RefreshTimeout time.Duration
+	// wait after a failed refresh before the key is refreshed again,
+	// defaults to 5s
+	RefreshBackoff time.Duration
+	// bound of a load shared by concurrent callers, it does not end with
+	// the ctx of one of them
+	LoadTimeout time.Duration
+	// called when a background refresh fails, the stale value is kept
+	OnRefreshError func(key string, err error)
+}
+
+// CacheLoader implements cache-aside reads with stampede protection.
+// Values written by the loader are wrapped in a small header carrying the
+// load duration and expiry, so such keys must be read through GetOrLoad.
+// Other values found under a key count as a miss and are replaced.
+type CacheLoader struct {
+	Cache Cache
+	opt   LoadOption
+	group flightGroup
+	mu    sync.Mutex
+	// keys being refreshed, with the end of their backoff after a failure
+	refreshing map[string]time.Time
+}
+
+// new cache loader
+func NewCacheLoader(c Cache, opt LoadOption) *CacheLoader {
+	if opt.LockTTL <= 0 {
+		opt.LockTTL = defaultLoadLockTTL
+	}
+	if opt.RefreshTimeout <= 0 {
+		opt.RefreshTimeout = defaultRefreshTimeout
+	}
+	if opt.LoadTimeout <= 0 {
+		opt.LoadTimeout = defaultLoadTimeout
+	}
+	if opt.RefreshBackoff <= 0 {
+		opt.RefreshBackoff = defaultRefreshBackoff
+	}
+	return &CacheLoader{
+		Cache: c,
+		opt:   opt,
+	}
+}
+
+// GetOrLoad returns the cached value of key or calls loader once per key
+// across concurrent callers and stores its result for ttl, rounded up to
+// whole seconds. Values are returned as []byte, the same shape as Get. The
+// load keeps the values of ctx but runs until LoadTimeout, a caller whose
+// ctx ends returns early without failing the others.
+func (l *CacheLoader) GetOrLoad(ctx context.Context, key string, ttl time.Duration, fn Loader) (interface{}, error) {
+	env, err := l.read(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if env != nil && !env.refreshDue(l.opt.Beta) {
+		return env.result()
+	}
+	val, err := l.group.Do(ctx, key, func() (interface{}, error) {
+		return l.shared(ctx, key, ttl, ttl, fn)
+	})
+	if err != nil && env != nil && !errors.Is(err, ErrNotFound) {
+		// an early refresh failed, the current value is still valid
+		return env.result()
+	}
+	return val, err
+}
+
+// GetOrLoadAs is GetOrLoad for typed values, encoded with codec.
+func (l *CacheLoader) GetOrLoadAs(ctx context.Context, key string, ttl time.Duration, codec Codec, v interface{}, fn Loader) error {
+	val, err := l.GetOrLoad(ctx, key, ttl, marshalLoader(codec, fn))
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(val.([]byte), v)
+}
+
+// GetOrRefresh serves stale values instead of blocking. The value is fresh
+// for softTTL and kept until hardTTL, a read in between returns it at once
+// while one background refresh per key runs. A failed refresh leaves the
+// stale value in place, only a miss past hardTTL waits for fn.
+func (l *CacheLoader) GetOrRefresh(ctx context.Context, key string, softTTL, hardTTL time.Duration, fn Loader) (interface{}, error) {
+	if hardTTL < softTTL {
+		hardTTL = softTTL
+	}
+	env, err := l.read(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if env == nil {
+		return l.group.Do(ctx, key, func() (interface{}, error) {
+			return l.shared(ctx, key, softTTL, hardTTL, fn)
+		})
+	}
+	if !time.Now().Before(env.expireAt) {
+		l.refresh(key, softTTL, hardTTL, fn)
+	}
+	return env.result()
+}
+
+// GetOrRefreshAs is GetOrRefresh for typed values, encoded with codec.
+func (l *CacheLoader) GetOrRefreshAs(ctx context.Context, key string, softTTL, hardTTL time.Duration, codec Codec, v interface{}, fn Loader) error {
+	val, err := l.GetOrRefresh(ctx, key, softTTL, hardTTL, marshalLoader(codec, fn))
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(val.([]byte), v)
+}
+
+func marshalLoader(codec Codec, fn Loader) Loader {
+	return func(ctx context.Context) (interface{}, error) {
+		res, err := fn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return codec.Marshal(res)
+	}
+}
+
+// refresh reloads key in the background unless a refresh is running or
+// failed less than RefreshBackoff ago. It is detached from the caller, whose
+// request may end before the load does.
+func (l *CacheLoader) refresh(key string, softTTL, hardTTL time.Duration, fn Loader) {
+	now := time.Now()
+	l.mu.Lock()
+	if until, ok := l.refreshing[key]; ok && (until.IsZero() || now.Before(until)) {
+		l.mu.Unlock()
+		return
+	}
+	if l.refreshing == nil {
+		l.refreshing = make(map[string]time.Time)
+	}
+	l.refreshing[key] = time.Time{}
+	l.mu.Unlock()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), l.opt.RefreshTimeout)
+		defer cancel()
+		_, err := l.group.Do(ctx, key, func() (interface{}, error) {
+			return l.load(ctx, key, softTTL, hardTTL, fn)
+		})
+		failed := err != nil && !errors.Is(err, ErrNotFound)
+		l.refreshed(key, failed)
+		if failed && l.opt.OnRefreshError != nil {
+			l.opt.OnRefreshError(key, err)
+		}
+	}()
+}
+
+// refreshed ends the refresh of key, a failed one starts its backoff. Ended
+// backoffs are dropped meanwhile.
+func (l *CacheLoader) refreshed(key string, failed bool) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.refreshing, key)
+	if !failed {
+		return
+	}
+	for k, until := range l.refreshing {
+		if !until.IsZero() && !now.Before(until) {
+			delete(l.refreshing, k)
+		}
+	}
+	l.refreshing[key] = now.Add(l.opt.RefreshBackoff)
+}
+
+func (l *CacheLoader) read(ctx context.Context, key string) (*envelope, error) {
+	val, err := l.Cache.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := replyBytes(val)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	env, err := decodeEnvelope(data)
+	if err != nil {
+		// written by plain Set, e.g. older cache-aside code, reload it
+		return nil, nil
+	}
+	return env, nil
+}
+
+// shared loads for every caller waiting on key, detached from the ctx of
+// the first one.
+func (l *CacheLoader) shared(ctx context.Context, key string, softTTL, hardTTL time.Duration, fn Loader) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(detached{ctx}, l.opt.LoadTimeout)
+	defer cancel()
+	return l.load(ctx, key, softTTL, hardTTL, fn)
+}
+
+// detached keeps the values of a context, not its deadline or cancellation.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// load calls fn and stores its value for hardTTL, considered fresh for
+// softTTL.
+func (l *CacheLoader) load(ctx context.Context, key string, softTTL, hardTTL time.Duration, fn Loader) (interface{}, error) {
+	// the lock bypasses the breaker, it is skipped while redis is failing and
+	// a lock error only costs the stampede protection
+	if rc := redisOf(l.Cache); l.opt.Lock && rc != nil && l.breakerClosed() {
+		lock, err := rc.TryAcquire(ctx, key, l.opt.LockTTL)
+		switch {
+		case err == nil:
+			defer lock.Release(ctx)
+		case errors.Is(err, ErrNotAcquired):
+			if env := l.wait(ctx, key); env != nil {
+				return env.result()
+			}
+		}
+	}
+	start := time.Now()
+	val, err := fn(ctx)
+	delta := time.Since(start)
+	if errors.Is(err, ErrNotFound) && l.opt.NegativeTTL > 0 {
+		env := &envelope{kind: envelopeNegative, delta: delta, expireAt: start.Add(l.opt.NegativeTTL)}
+		l.Cache.SetEx(ctx, key, env.encode(), seconds(l.opt.NegativeTTL))
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	env := &envelope{kind: envelopeValue, delta: delta, expireAt: start.Add(softTTL), value: formatValue(val)}
+	if _, err := l.Cache.SetEx(ctx, key, env.encode(), seconds(hardTTL)); err != nil {
+		return nil, err
+	}
+	return env.value, nil
+}
+
+func (l *CacheLoader) breakerClosed() bool {
+	b := breakerOf(l.Cache)
+	return b == nil || b.State() == StateClosed
+}
+
+// wait polls key while another instance holds the load lock.
+func (l *CacheLoader) wait(ctx context.Context, key string) *envelope {
+	deadline := time.Now().Add(l.opt.LockTTL)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(loadPollInterval):
+		}
+		if env, _ := l.read(ctx, key); env != nil {
+			return env
+		}
+	}
+	return nil
+}
+
+func seconds(d time.Duration) int {
+	sec := int((d + time.Second - 1) / time.Second)
+	if sec < 1 {
+		sec = 1
+	}
+	return sec
+}
+
+// redisOf returns the redis backend behind c, if any.
+func redisOf(c Cache) *CacheBaseRedis {
+	switch v := c.(type) {
+	case *CacheBaseRedis:
+		return v
+	case *CacheBaseNear:
+		return v.Remote
+	case *MeteredCache:
+		return redisOf(v.Cache)
+	case *TransformCache:
+		return redisOf(v.Cache)
+	case *BreakerCache:
+		return redisOf(v.Cache)
+	}
+	return nil
+}
+
+type envelope struct {
+	kind     byte
+	delta    time.Duration
+	expireAt time.Time
+	value    []byte
+}
+
+func (e *envelope) encode() []byte {
+	buf := make([]byte, envelopeHeader, envelopeHeader+len(e.value))
+	buf[0] = e.kind
+	binary.BigEndian.PutUint64(buf[1:9], uint64(e.delta.Milliseconds()))
+	binary.BigEndian.PutUint64(buf[9:17], uint64(e.expireAt.UnixMilli()))
+	return append(buf, e.value...)
+}
+
+func decodeEnvelope(data []byte) (*envelope, error) {
+	if len(data) < envelopeHeader || (data[0] != envelopeValue && data[0] != envelopeNegative) {
+		return nil, errors.New("cache: value was not written by GetOrLoad")
+	}
+	return &envelope{
+		kind:     data[0],
+		delta:    time.Duration(binary.BigEndian.Uint64(data[1:9])) * time.Millisecond,
+		expireAt: time.UnixMilli(int64(binary.BigEndian.Uint64(data[9:17]))),
+		value:    data[envelopeHeader:],
+	}, nil
+}
+
+// refreshDue implements the XFetch early expiration test: the closer the
+// entry is to expiry and the slower it was to compute, the likelier a
+// refresh.
+func (e *envelope) refreshDue(beta float64) bool {
+	if beta <= 0 || e.kind != envelopeValue {
+		return false
+	}
+	gap := time.Duration(float64(e.delta) * beta * -math.Log(rand.Float64()))
+	return !time.Now().Add(gap).Before(e.expireAt)
+}
+
+func (e *envelope) result() (interface{}, error) {
+	if e.kind == envelopeNegative {
+		return nil, ErrNotFound
+	}
+	return e.value, nil
+}
+
+// flightGroup deduplicates concurrent calls sharing a key. The call runs on
+// its own goroutine, so every caller can stop waiting when its ctx ends.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done  chan struct{}
+	val   interface{}
+	err   error
+	panic *loadPanic
+}
+
+// loadPanic is raised in every caller of a call whose fn panicked.
+type loadPanic struct {
+	value interface{}
+	stack []byte
+}
+
+func (p *loadPanic) Error() string {
+	return fmt.Sprintf("cache: loader panicked: %v\n\n%s", p.value, p.stack)
+}
+
+func (g *flightGroup) Do(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	call, ok := g.calls[key]
+	if !ok {
+		call = &flightCall{done: make(chan struct{})}
+		g.calls[key] = call
+		go g.run(key, call, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if call.panic != nil {
+		panic(call.panic)
+	}
+	return call.val, call.err
+}
+
+// run releases the waiters and forgets the call however fn ends.
+func (g *flightGroup) run(key string, call *flightCall, fn func() (interface{}, error)) {
+	returned := false
+	defer func() {
+		if !returned {
+			if r := recover(); r != nil {
+				call.panic = &loadPanic{value: r, stack: debug.Stack()}
+			} else {
+				call.err = errLoadExited
+			}
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	call.val, call.err = fn()
+	returned = true
+}
+
+/*
+loader helpers for caller
+*/
+
+func GetOrLoad(ctx context.Context, key string, ttl time.Duration, fn Loader) (interface{}, error) {
+	return loader.GetOrLoad(ctx, key, ttl, fn)
+}
+
+func GetOrLoadAs(ctx context.Context, key string, ttl time.Duration, v interface{}, fn Loader) error {
+	return loader.GetOrLoadAs(ctx, key, ttl, typed.Codec, v, fn)
+}
+
+func GetOrRefresh(ctx context.Context, key string, softTTL, hardTTL time.Duration, fn Loader) (interface{}, error) {
+	return loader.GetOrRefresh(ctx, key, softTTL, hardTTL, fn)
+}
+
+func GetOrRefreshAs(ctx context.Context, key string, softTTL, hardTTL time.Duration, v interface{}, fn Loader) error {
+	return loader.GetOrRefreshAs(ctx, key, softTTL, hardTTL, typed.Codec, v, fn)
+}
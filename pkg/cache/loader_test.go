@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestLoader(opt LoadOption) *CacheLoader {
+	return NewCacheLoader(newTestMemory(CacheOption{}), opt)
+}
+
+func TestLoaderDeduplicates(t *testing.T) {
+	ctx := context.Background()
+	l := newTestLoader(LoadOption{})
+	var calls int32
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "v", nil
+	}
+	var wg sync.WaitGroup
+	results := make([]interface{}, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = l.GetOrLoad(ctx, "k", time.Minute, fn)
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("loader ran %d times, want 1", calls)
+	}
+	for i, val := range results {
+		if b, _ := val.([]byte); string(b) != "v" {
+			t.Errorf("caller %d got %v", i, val)
+		}
+	}
+	// the stored value is served without loading
+	if val, _ := l.GetOrLoad(ctx, "k", time.Minute, fn); string(val.([]byte)) != "v" || calls != 1 {
+		t.Errorf("cached read = %v after %d loads", val, calls)
+	}
+}
+
+func TestLoaderCachesNotFound(t *testing.T) {
+	ctx := context.Background()
+	l := newTestLoader(LoadOption{NegativeTTL: time.Minute})
+	calls := 0
+	fn := func(ctx context.Context) (interface{}, error) {
+		calls++
+		return nil, ErrNotFound
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := l.GetOrLoad(ctx, "k", time.Minute, fn); err != ErrNotFound {
+			t.Fatalf("GetOrLoad error = %v, want ErrNotFound", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("loader ran %d times, want 1", calls)
+	}
+	// other errors are not cached
+	l = newTestLoader(LoadOption{NegativeTTL: time.Minute})
+	failure := errors.New("down")
+	for i := 0; i < 2; i++ {
+		l.GetOrLoad(ctx, "k", time.Minute, func(ctx context.Context) (interface{}, error) {
+			calls++
+			return nil, failure
+		})
+	}
+	if calls != 3 {
+		t.Errorf("failed loads = %d, want 2", calls-1)
+	}
+}
+
+func TestLoaderPanic(t *testing.T) {
+	ctx := context.Background()
+	l := newTestLoader(LoadOption{})
+	func() {
+		defer func() {
+			p, ok := recover().(*loadPanic)
+			if !ok || p.value != "boom" {
+				t.Errorf("recovered %v, want the loader panic", p)
+			}
+		}()
+		l.GetOrLoad(ctx, "k", time.Minute, func(ctx context.Context) (interface{}, error) {
+			panic("boom")
+		})
+	}()
+	// the key is released for the next load
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		val, err := l.GetOrLoad(ctx, "k", time.Minute, func(ctx context.Context) (interface{}, error) {
+			return "v", nil
+		})
+		if err != nil || string(val.([]byte)) != "v" {
+			t.Errorf("GetOrLoad = %v, %v", val, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("GetOrLoad blocked after a panic")
+	}
+}
+
+func TestLoaderOutlivesCaller(t *testing.T) {
+	l := newTestLoader(LoadOption{})
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		select {
+		case <-release:
+			return "v", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	first, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := l.GetOrLoad(first, "k", time.Minute, fn)
+		errs <- err
+	}()
+	vals := make(chan interface{}, 1)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		val, _ := l.GetOrLoad(context.Background(), "k", time.Minute, fn)
+		vals <- val
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("cancelled caller error = %v, want context.Canceled", err)
+	}
+	close(release)
+	if val := <-vals; val == nil || string(val.([]byte)) != "v" {
+		t.Errorf("other caller got %v", val)
+	}
+}
+
+func TestLoaderRefreshBackoff(t *testing.T) {
+	ctx := context.Background()
+	failed := make(chan string, 10)
+	l := newTestLoader(LoadOption{
+		RefreshBackoff: 50 * time.Millisecond,
+		OnRefreshError: func(key string, err error) { failed <- key },
+	})
+	l.GetOrRefresh(ctx, "k", time.Millisecond, time.Minute, func(ctx context.Context) (interface{}, error) {
+		return "v", nil
+	})
+	time.Sleep(5 * time.Millisecond)
+	var calls int32
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, errors.New("down")
+	}
+	// the stale value is served while one refresh fails
+	if val, _ := l.GetOrRefresh(ctx, "k", time.Millisecond, time.Minute, fn); string(val.([]byte)) != "v" {
+		t.Fatalf("GetOrRefresh = %v, want the stale value", val)
+	}
+	<-failed
+	for i := 0; i < 5; i++ {
+		l.GetOrRefresh(ctx, "k", time.Millisecond, time.Minute, fn)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("refreshed %d times during the backoff, want 1", n)
+	}
+	time.Sleep(60 * time.Millisecond)
+	l.GetOrRefresh(ctx, "k", time.Millisecond, time.Minute, fn)
+	<-failed
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("refreshed %d times after the backoff, want 2", n)
+	}
+}
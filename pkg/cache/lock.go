@@ -0,0 +1,175 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+const (
+	lockPrefix        = "kit:lock:"
+	lockRetryInterval = 100 * time.Millisecond
+)
+
+var (
+	ErrNotAcquired = errors.New("cache: lock is held by another owner")
+	ErrLockLost    = errors.New("cache: lock is no longer held")
+	ErrUnsupported = errors.New("cache: operation not supported by backend")
+
+	unlockScript = redigo.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+	refreshScript = redigo.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+// Lock is a lease on a named redis key identified by a random token, so that
+// only the owner can refresh or release it.
+type Lock struct {
+	Name     string
+	cache    *CacheBaseRedis
+	key      string
+	token    string
+	ttl      time.Duration
+	mu       sync.Mutex
+	released bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// TryAcquire takes the lock once and fails with ErrNotAcquired if it is held.
+func (c *CacheBaseRedis) TryAcquire(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	l := &Lock{
+		Name:  name,
+		cache: c,
+		key:   c.Key(lockPrefix + name),
+		token: newInstanceID(),
+		ttl:   ttl,
+		done:  make(chan struct{}),
+	}
+	r := c.get(ctx, l.key)
+	defer r.Close()
+	_, err := redigo.String(r.Do("SET", l.key, l.token, "NX", "PX", ttl.Milliseconds()))
+	if err == redigo.ErrNil {
+		return nil, ErrNotAcquired
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Acquire blocks until the lock is taken or ctx is done.
+func (c *CacheBaseRedis) Acquire(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	for {
+		l, err := c.TryAcquire(ctx, name, ttl)
+		if !errors.Is(err, ErrNotAcquired) {
+			return l, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, wrapError(ctx.Err())
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// Refresh extends the lease to ttl if the lock is still owned.
+func (l *Lock) Refresh(ctx context.Context, ttl time.Duration) error {
+	r := l.cache.get(ctx, l.key)
+	defer r.Close()
+	ok, err := redigo.Bool(refreshScript.Do(r, l.key, l.token, ttl.Milliseconds()))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// Release gives the lock up, it is a no-op for the key if the lease already
+// passed to another owner.
+func (l *Lock) Release(ctx context.Context) error {
+	l.mu.Lock()
+	if !l.released {
+		l.released = true
+		if l.stop != nil {
+			close(l.stop)
+		} else {
+			close(l.done)
+		}
+	}
+	l.mu.Unlock()
+	r := l.cache.get(ctx, l.key)
+	defer r.Close()
+	ok, err := redigo.Bool(unlockScript.Do(r, l.key, l.token))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// KeepAlive refreshes the lease every third of its ttl until Release. The
+// returned channel is closed on Release or when the lease was lost.
+func (l *Lock) KeepAlive() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.released || l.stop != nil {
+		return l.done
+	}
+	l.stop = make(chan struct{})
+	go l.keepAlive(l.stop)
+	return l.done
+}
+
+func (l *Lock) keepAlive(stop chan struct{}) {
+	defer close(l.done)
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+			err := l.Refresh(ctx, l.ttl)
+			cancel()
+			if errors.Is(err, ErrLockLost) {
+				return
+			}
+		}
+	}
+}
+
+/*
+lock helpers for caller
+*/
+
+func TryAcquire(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return nil, err
+	}
+	return rc.TryAcquire(ctx, name, ttl)
+}
+
+func Acquire(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return nil, err
+	}
+	return rc.Acquire(ctx, name, ttl)
+}
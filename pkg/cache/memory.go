@@ -0,0 +1,387 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+const (
+	defaultCleanupInterval = time.Minute
+)
+
+type memoryEntry struct {
+	key      string
+	value    []byte
+	expireAt time.Time
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+// CacheBaseMemory keeps values in process with ttl expiry and lru eviction.
+// Values are stored the way redis would store them, so Get returns []byte.
+type CacheBaseMemory struct {
+	mu         sync.Mutex
+	items      map[string]*list.Element
+	lru        *list.List
+	size       int64
+	maxEntries int
+	maxBytes   int64
+	namespace  string
+	tags       map[string]map[string]struct{}
+	stop       chan struct{}
+	closeOnce  sync.Once
+}
+
+// new cache base memory
+func NewCacheBaseMemory(opt CacheOption) Cache {
+	c := &CacheBaseMemory{
+		items:      make(map[string]*list.Element),
+		lru:        list.New(),
+		maxEntries: opt.MaxEntries,
+		maxBytes:   opt.MaxBytes,
+		namespace:  namespaceOf(opt),
+		stop:       make(chan struct{}),
+	}
+	interval := opt.CleanupInterval
+	if interval == 0 {
+		interval = defaultCleanupInterval
+	}
+	if interval > 0 {
+		go c.janitor(interval)
+	}
+	return c
+}
+
+// Close stops the background janitor.
+func (c *CacheBaseMemory) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+	})
+	return nil
+}
+
+func (c *CacheBaseMemory) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.deleteExpired()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *CacheBaseMemory) deleteExpired() {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.items {
+		if el.Value.(*memoryEntry).expired(now) {
+			c.removeElement(el)
+		}
+	}
+	c.pruneTags()
+}
+
+// lookup returns the live element for key, dropping it if expired.
+// caller must hold c.mu.
+func (c *CacheBaseMemory) lookup(key string, now time.Time) *list.Element {
+	el, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	if el.Value.(*memoryEntry).expired(now) {
+		c.removeElement(el)
+		return nil
+	}
+	return el
+}
+
+// store inserts or replaces key and evicts the least recently used entries
+// until the configured bounds are met. caller must hold c.mu.
+func (c *CacheBaseMemory) store(key string, value []byte, expireAt time.Time) {
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*memoryEntry)
+		c.size += int64(len(value)) - int64(len(entry.value))
+		entry.value = value
+		entry.expireAt = expireAt
+		c.lru.MoveToFront(el)
+	} else {
+		entry := &memoryEntry{key: key, value: value, expireAt: expireAt}
+		c.items[key] = c.lru.PushFront(entry)
+		c.size += int64(len(key) + len(value))
+	}
+	for c.lru.Len() > 1 && c.overflow() {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+func (c *CacheBaseMemory) overflow() bool {
+	if c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		return true
+	}
+	return c.maxBytes > 0 && c.size > c.maxBytes
+}
+
+func (c *CacheBaseMemory) removeElement(el *list.Element) {
+	entry := c.lru.Remove(el).(*memoryEntry)
+	delete(c.items, entry.key)
+	c.size -= int64(len(entry.key) + len(entry.value))
+}
+
+func (c *CacheBaseMemory) Get(ctx context.Context, key string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el := c.lookup(key, time.Now())
+	if el == nil {
+		return nil, nil
+	}
+	c.lru.MoveToFront(el)
+	value := el.Value.(*memoryEntry).value
+	return append([]byte(nil), value...), nil
+}
+
+func (c *CacheBaseMemory) Set(ctx context.Context, key string, value interface{}) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.store(key, formatValue(value), time.Time{})
+	return "OK", nil
+}
+
+func (c *CacheBaseMemory) SetEx(ctx context.Context, key string, value interface{}, sec int) (interface{}, error) {
+	if sec <= 0 {
+		return nil, redigo.Error("ERR invalid expire time in 'setex' command")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.store(key, formatValue(value), time.Now().Add(time.Duration(sec)*time.Second))
+	return "OK", nil
+}
+
+func (c *CacheBaseMemory) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		if el := c.lookup(key, now); el != nil {
+			c.lru.MoveToFront(el)
+			values[i] = append([]byte(nil), el.Value.(*memoryEntry).value...)
+		}
+	}
+	return values, nil
+}
+
+func (c *CacheBaseMemory) MSet(ctx context.Context, values map[string]interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, value := range values {
+		c.store(key, formatValue(value), time.Time{})
+	}
+	return nil
+}
+
+func (c *CacheBaseMemory) MSetEx(ctx context.Context, values map[string]interface{}, sec int) error {
+	if sec <= 0 {
+		return redigo.Error("ERR invalid expire time in 'setex' command")
+	}
+	expireAt := time.Now().Add(time.Duration(sec) * time.Second)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, value := range values {
+		c.store(key, formatValue(value), expireAt)
+	}
+	return nil
+}
+
+func (c *CacheBaseMemory) DeleteMany(ctx context.Context, keys ...string) (int64, error) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int64
+	for _, key := range keys {
+		if el := c.lookup(key, now); el != nil {
+			c.removeElement(el)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (c *CacheBaseMemory) Delete(ctx context.Context, key string) (bool, error) {
+	n, err := c.DeleteMany(ctx, key)
+	return n > 0, err
+}
+
+func (c *CacheBaseMemory) Exists(ctx context.Context, key string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lookup(key, time.Now()) != nil, nil
+}
+
+func (c *CacheBaseMemory) TTL(ctx context.Context, key string) (time.Duration, error) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el := c.lookup(key, now)
+	if el == nil {
+		return 0, ErrNotFound
+	}
+	expireAt := el.Value.(*memoryEntry).expireAt
+	if expireAt.IsZero() {
+		return NoExpiry, nil
+	}
+	return expireAt.Sub(now), nil
+}
+
+func (c *CacheBaseMemory) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return c.ExpireAt(ctx, key, time.Now().Add(ttl))
+}
+
+func (c *CacheBaseMemory) ExpireAt(ctx context.Context, key string, at time.Time) (bool, error) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el := c.lookup(key, now)
+	if el == nil {
+		return false, nil
+	}
+	if !at.After(now) {
+		c.removeElement(el)
+		return true, nil
+	}
+	el.Value.(*memoryEntry).expireAt = at
+	return true, nil
+}
+
+func (c *CacheBaseMemory) Persist(ctx context.Context, key string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el := c.lookup(key, time.Now())
+	if el == nil {
+		return false, nil
+	}
+	entry := el.Value.(*memoryEntry)
+	persisted := !entry.expireAt.IsZero()
+	entry.expireAt = time.Time{}
+	return persisted, nil
+}
+
+func (c *CacheBaseMemory) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return c.IncrBy(ctx, key, 1, ttl)
+}
+
+func (c *CacheBaseMemory) IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var value int64
+	var expireAt time.Time
+	if el := c.lookup(key, now); el != nil {
+		entry := el.Value.(*memoryEntry)
+		v, err := strconv.ParseInt(string(entry.value), 10, 64)
+		if err != nil {
+			return 0, redigo.Error("ERR value is not an integer or out of range")
+		}
+		value, expireAt = v, entry.expireAt
+	}
+	value += n
+	if expireAt.IsZero() && ttl > 0 {
+		expireAt = now.Add(ttl)
+	}
+	c.store(key, strconv.AppendInt(nil, value, 10), expireAt)
+	return value, nil
+}
+
+func (c *CacheBaseMemory) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lookup(key, now) != nil {
+		return false, nil
+	}
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = now.Add(ttl)
+	}
+	c.store(key, formatValue(value), expireAt)
+	return true, nil
+}
+
+// setTTL stores value with a ttl of arbitrary precision, zero means no expiry.
+func (c *CacheBaseMemory) setTTL(key string, value interface{}, ttl time.Duration) {
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.store(key, formatValue(value), expireAt)
+}
+
+func (c *CacheBaseMemory) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *CacheBaseMemory) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = make(map[string]*list.Element)
+	c.tags = nil
+	c.lru.Init()
+	c.size = 0
+}
+
+func (c *CacheBaseMemory) Overdue(ctx context.Context, key interface{}) bool {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el := c.lookup(string(formatValue(key)), now)
+	if el == nil {
+		return false
+	}
+	expireAt := el.Value.(*memoryEntry).expireAt
+	return !expireAt.IsZero() && expireAt.Sub(now) > time.Second
+}
+
+// formatValue converts value to bytes following the rules redigo uses
+// when writing command arguments.
+func formatValue(value interface{}) []byte {
+	switch v := value.(type) {
+	case []byte:
+		return append([]byte(nil), v...)
+	case string:
+		return []byte(v)
+	case int:
+		return strconv.AppendInt(nil, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(nil, v, 10)
+	case float64:
+		return strconv.AppendFloat(nil, v, 'g', -1, 64)
+	case bool:
+		if v {
+			return []byte("1")
+		}
+		return []byte("0")
+	case nil:
+		return []byte{}
+	case redigo.Argument:
+		return formatValue(v.RedisArg())
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
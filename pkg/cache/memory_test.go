@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestMemory(opt CacheOption) *CacheBaseMemory {
+	opt.CleanupInterval = -1
+	return NewCacheBaseMemory(opt).(*CacheBaseMemory)
+}
+
+func TestMemoryEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	c := newTestMemory(CacheOption{MaxEntries: 2})
+	c.Set(ctx, "a", "1")
+	c.Set(ctx, "b", "2")
+	// a becomes the most recently used
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", "3")
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if ok, _ := c.Exists(ctx, key); ok != want {
+			t.Errorf("Exists(%q) = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestMemoryEvictsBeyondMaxBytes(t *testing.T) {
+	ctx := context.Background()
+	// an entry is its key and value, 1+4 bytes
+	c := newTestMemory(CacheOption{MaxBytes: 10})
+	c.Set(ctx, "a", "aaaa")
+	c.Set(ctx, "b", "bbbb")
+	c.Set(ctx, "c", "cccc")
+	if ok, _ := c.Exists(ctx, "a"); ok {
+		t.Error("a was not evicted")
+	}
+	if c.size > 10 {
+		t.Errorf("size = %d, want at most 10", c.size)
+	}
+	// replacing a value accounts for the size difference
+	c.Set(ctx, "c", "cc")
+	if c.size != 8 {
+		t.Errorf("size = %d, want 8", c.size)
+	}
+	// an entry larger than the bound is still kept alone
+	c.Set(ctx, "d", strings.Repeat("d", 20))
+	if c.lru.Len() != 1 {
+		t.Errorf("len = %d, want 1", c.lru.Len())
+	}
+	if v, _ := c.Get(ctx, "d"); v == nil {
+		t.Error("d was evicted")
+	}
+}
+
+func TestMemoryExpiry(t *testing.T) {
+	ctx := context.Background()
+	c := newTestMemory(CacheOption{})
+	c.Set(ctx, "a", "1")
+	c.Set(ctx, "b", "2")
+	if ttl, _ := c.TTL(ctx, "a"); ttl != NoExpiry {
+		t.Errorf("TTL = %v, want NoExpiry", ttl)
+	}
+	c.Expire(ctx, "a", 20*time.Millisecond)
+	if ttl, _ := c.TTL(ctx, "a"); ttl <= 0 || ttl > 20*time.Millisecond {
+		t.Errorf("TTL = %v, want within 20ms", ttl)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if v, _ := c.Get(ctx, "a"); v != nil {
+		t.Errorf("Get = %q, want expired", v)
+	}
+	if _, err := c.TTL(ctx, "a"); err != ErrNotFound {
+		t.Errorf("TTL error = %v, want ErrNotFound", err)
+	}
+	// like redis, Persist only reports removing a ttl
+	if ok, _ := c.Persist(ctx, "b"); ok {
+		t.Error("Persist removed a ttl from a key without one")
+	}
+	c.Expire(ctx, "b", time.Minute)
+	if ok, _ := c.Persist(ctx, "b"); !ok {
+		t.Error("Persist kept the ttl")
+	}
+	// a deadline in the past deletes the key
+	c.ExpireAt(ctx, "b", time.Now().Add(-time.Second))
+	if ok, _ := c.Exists(ctx, "b"); ok {
+		t.Error("b outlived its deadline")
+	}
+	if _, err := c.SetEx(ctx, "c", "3", 0); err == nil {
+		t.Error("SetEx accepted a zero ttl")
+	}
+}
+
+func TestMemoryDeleteExpired(t *testing.T) {
+	ctx := context.Background()
+	c := newTestMemory(CacheOption{})
+	c.Set(ctx, "a", "1")
+	c.Set(ctx, "b", "2")
+	c.Expire(ctx, "a", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	c.deleteExpired()
+	if len(c.items) != 1 || c.lru.Len() != 1 {
+		t.Errorf("items = %d, lru = %d, want 1", len(c.items), c.lru.Len())
+	}
+	if c.size != 2 {
+		t.Errorf("size = %d, want 2", c.size)
+	}
+}
+
+func TestMemoryScan(t *testing.T) {
+	ctx := context.Background()
+	c := newTestMemory(CacheOption{})
+	for _, key := range []string{"user:1", "user:2", "user:10", "order:1", "user:[x]"} {
+		c.Set(ctx, key, "v")
+	}
+	c.Expire(ctx, "user:2", -time.Second)
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"", []string{"order:1", "user:1", "user:10", "user:[x]"}},
+		{"user:*", []string{"user:1", "user:10", "user:[x]"}},
+		{"user:?", []string{"user:1"}},
+		{"user:1[0-9]", []string{"user:10"}},
+		{`user:\[x\]`, []string{"user:[x]"}},
+		{"*:1", []string{"order:1", "user:1"}},
+		{"none*", nil},
+	}
+	for _, tt := range tests {
+		var keys []string
+		it := c.Scan(ctx, tt.pattern)
+		for it.Next() {
+			keys = append(keys, it.Key())
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("Scan(%q): %v", tt.pattern, err)
+		}
+		sort.Strings(keys)
+		if strings.Join(keys, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("Scan(%q) = %v, want %v", tt.pattern, keys, tt.want)
+		}
+	}
+}
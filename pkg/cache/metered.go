@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aivencs/kit/pkg/internal/redisconn"
+	"github.com/aivencs/kit/pkg/metrics"
+)
+
+const metricsComponent = "cache"
+
+// MeteredCache reports the operations of a Cache to a collector, lookups
+// count their hits and misses.
+type MeteredCache struct {
+	Cache
+	collector metrics.Collector
+}
+
+// new metered cache, the pool of a redis backed cache is registered too
+func NewMeteredCache(c Cache, collector metrics.Collector) Cache {
+	if rc := redisOf(c); rc != nil {
+		collector.RegisterPool(metricsComponent, rc.PoolStats)
+	}
+	return &MeteredCache{Cache: c, collector: collector}
+}
+
+// PoolStats sums the stats of every node pool on a cluster.
+func (c *CacheBaseRedis) PoolStats() metrics.PoolStats {
+	return redisconn.PoolStats(c.Pool, c.Cluster)
+}
+
+// observe records op, a missing key is not an error.
+func (c *MeteredCache) observe(op string, start time.Time, err error) {
+	if errors.Is(err, ErrNotFound) {
+		err = nil
+	}
+	c.collector.ObserveOp(metricsComponent, op, time.Since(start), err)
+}
+
+func (c *MeteredCache) lookup(op string, hit bool) {
+	if hit {
+		c.collector.ObserveLookup(metricsComponent, op, 1, 0)
+	} else {
+		c.collector.ObserveLookup(metricsComponent, op, 0, 1)
+	}
+}
+
+func (c *MeteredCache) Get(ctx context.Context, key string) (interface{}, error) {
+	start := time.Now()
+	val, err := c.Cache.Get(ctx, key)
+	c.observe("get", start, err)
+	if err == nil {
+		c.lookup("get", val != nil)
+	}
+	return val, err
+}
+
+func (c *MeteredCache) Set(ctx context.Context, key string, value interface{}) (interface{}, error) {
+	start := time.Now()
+	res, err := c.Cache.Set(ctx, key, value)
+	c.observe("set", start, err)
+	return res, err
+}
+
+func (c *MeteredCache) Overdue(ctx context.Context, key interface{}) bool {
+	start := time.Now()
+	ok := c.Cache.Overdue(ctx, key)
+	c.observe("overdue", start, nil)
+	return ok
+}
+
+func (c *MeteredCache) SetEx(ctx context.Context, key string, value interface{}, sec int) (interface{}, error) {
+	start := time.Now()
+	res, err := c.Cache.SetEx(ctx, key, value, sec)
+	c.observe("setex", start, err)
+	return res, err
+}
+
+func (c *MeteredCache) SetWithTags(ctx context.Context, key string, value interface{}, tags ...string) (interface{}, error) {
+	start := time.Now()
+	res, err := c.Cache.SetWithTags(ctx, key, value, tags...)
+	c.observe("set_with_tags", start, err)
+	return res, err
+}
+
+func (c *MeteredCache) SetExWithTags(ctx context.Context, key string, value interface{}, sec int, tags ...string) (interface{}, error) {
+	start := time.Now()
+	res, err := c.Cache.SetExWithTags(ctx, key, value, sec, tags...)
+	c.observe("setex_with_tags", start, err)
+	return res, err
+}
+
+func (c *MeteredCache) InvalidateTags(ctx context.Context, tags ...string) (int64, error) {
+	start := time.Now()
+	n, err := c.Cache.InvalidateTags(ctx, tags...)
+	c.observe("invalidate_tags", start, err)
+	return n, err
+}
+
+func (c *MeteredCache) Delete(ctx context.Context, key string) (bool, error) {
+	start := time.Now()
+	ok, err := c.Cache.Delete(ctx, key)
+	c.observe("delete", start, err)
+	return ok, err
+}
+
+func (c *MeteredCache) Exists(ctx context.Context, key string) (bool, error) {
+	start := time.Now()
+	ok, err := c.Cache.Exists(ctx, key)
+	c.observe("exists", start, err)
+	if err == nil {
+		c.lookup("exists", ok)
+	}
+	return ok, err
+}
+
+func (c *MeteredCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	start := time.Now()
+	ttl, err := c.Cache.TTL(ctx, key)
+	c.observe("ttl", start, err)
+	return ttl, err
+}
+
+func (c *MeteredCache) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	start := time.Now()
+	ok, err := c.Cache.Expire(ctx, key, ttl)
+	c.observe("expire", start, err)
+	return ok, err
+}
+
+func (c *MeteredCache) ExpireAt(ctx context.Context, key string, at time.Time) (bool, error) {
+	start := time.Now()
+	ok, err := c.Cache.ExpireAt(ctx, key, at)
+	c.observe("expireat", start, err)
+	return ok, err
+}
+
+func (c *MeteredCache) Persist(ctx context.Context, key string) (bool, error) {
+	start := time.Now()
+	ok, err := c.Cache.Persist(ctx, key)
+	c.observe("persist", start, err)
+	return ok, err
+}
+
+func (c *MeteredCache) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	start := time.Now()
+	n, err := c.Cache.Incr(ctx, key, ttl)
+	c.observe("incr", start, err)
+	return n, err
+}
+
+func (c *MeteredCache) IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	start := time.Now()
+	v, err := c.Cache.IncrBy(ctx, key, n, ttl)
+	c.observe("incrby", start, err)
+	return v, err
+}
+
+func (c *MeteredCache) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	start := time.Now()
+	ok, err := c.Cache.SetNX(ctx, key, value, ttl)
+	c.observe("setnx", start, err)
+	return ok, err
+}
+
+func (c *MeteredCache) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	start := time.Now()
+	values, err := c.Cache.MGet(ctx, keys...)
+	c.observe("mget", start, err)
+	if err == nil {
+		hits := 0
+		for _, val := range values {
+			if val != nil {
+				hits++
+			}
+		}
+		c.collector.ObserveLookup(metricsComponent, "mget", hits, len(values)-hits)
+	}
+	return values, err
+}
+
+func (c *MeteredCache) MSet(ctx context.Context, values map[string]interface{}) error {
+	start := time.Now()
+	err := c.Cache.MSet(ctx, values)
+	c.observe("mset", start, err)
+	return err
+}
+
+func (c *MeteredCache) MSetEx(ctx context.Context, values map[string]interface{}, sec int) error {
+	start := time.Now()
+	err := c.Cache.MSetEx(ctx, values, sec)
+	c.observe("msetex", start, err)
+	return err
+}
+
+func (c *MeteredCache) DeleteMany(ctx context.Context, keys ...string) (int64, error) {
+	start := time.Now()
+	n, err := c.Cache.DeleteMany(ctx, keys...)
+	c.observe("delete_many", start, err)
+	return n, err
+}
+
+// Scan is counted when the iterator is created, its pages are not timed.
+func (c *MeteredCache) Scan(ctx context.Context, pattern string) *KeyIterator {
+	start := time.Now()
+	it := c.Cache.Scan(ctx, pattern)
+	c.observe("scan", start, nil)
+	return it
+}
+
+func (c *MeteredCache) Flush(ctx context.Context, namespace string) (int64, error) {
+	start := time.Now()
+	n, err := c.Cache.Flush(ctx, namespace)
+	c.observe("flush", start, err)
+	return n, err
+}
@@ -0,0 +1,314 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+const scanCount = 100
+
+var ErrNoNamespace = errors.New("cache: flush needs a namespace")
+
+// KeyBuilder maps the key of a caller to the key stored in redis. Scan and
+// Flush expect the namespace to be a prefix of the built key.
+type KeyBuilder func(namespace, key string) string
+
+// DefaultKeyBuilder stores keys as <namespace>:<key>, bare without namespace.
+func DefaultKeyBuilder(namespace, key string) string {
+	if namespace == "" {
+		return key
+	}
+	return namespace + ":" + key
+}
+
+// namespaceOf returns Namespace, or <database>:<table> from the non-empty
+// parts of Database and Table.
+func namespaceOf(opt CacheOption) string {
+	if opt.Namespace != "" {
+		return opt.Namespace
+	}
+	var parts []string
+	for _, part := range []string{opt.Database, opt.Table} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ":")
+}
+
+// KeyIterator walks the keys found by Scan, fetching them page by page.
+type KeyIterator struct {
+	fetch func() ([]string, bool, error)
+	keys  []string
+	key   string
+	done  bool
+	err   error
+}
+
+// Next advances to the next key, it returns false once the keys are
+// exhausted or fetching failed.
+func (it *KeyIterator) Next() bool {
+	for len(it.keys) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		it.keys, it.done, it.err = it.fetch()
+	}
+	it.key, it.keys = it.keys[0], it.keys[1:]
+	return true
+}
+
+// Key returns the current key without its namespace.
+func (it *KeyIterator) Key() string {
+	return it.key
+}
+
+func (it *KeyIterator) Err() error {
+	return it.err
+}
+
+/*
+redis
+*/
+
+// Key returns the key stored in redis for key, for commands sent through
+// Batch or Conn which are not namespaced.
+func (c *CacheBaseRedis) Key(key string) string {
+	return c.build(c.namespace, key)
+}
+
+func (c *CacheBaseRedis) Namespace() string {
+	return c.namespace
+}
+
+func (c *CacheBaseRedis) keys(keys []string) []string {
+	built := make([]string, len(keys))
+	for i, key := range keys {
+		built[i] = c.Key(key)
+	}
+	return built
+}
+
+// Scan iterates the keys of the namespace matching the glob pattern, every
+// master is visited on a cluster. Keys written during the scan may be missed
+// and a key may be returned twice, as with SCAN.
+func (c *CacheBaseRedis) Scan(ctx context.Context, pattern string) *KeyIterator {
+	if pattern == "" {
+		pattern = "*"
+	}
+	prefix := c.Key("")
+	keys := c.scan(ctx, c.Key(pattern))
+	return &KeyIterator{fetch: func() ([]string, bool, error) {
+		page, done, err := keys()
+		for i, key := range page {
+			page[i] = strings.TrimPrefix(key, prefix)
+		}
+		return page, done, err
+	}}
+}
+
+// Flush deletes every key of namespace and returns how many were deleted.
+func (c *CacheBaseRedis) Flush(ctx context.Context, namespace string) (int64, error) {
+	if namespace == "" {
+		return 0, ErrNoNamespace
+	}
+	keys := c.scan(ctx, c.build(namespace, "*"))
+	var n int64
+	for {
+		page, done, err := keys()
+		if err != nil {
+			return n, err
+		}
+		deleted, err := c.deleteKeys(ctx, page)
+		n += deleted
+		if err != nil || done {
+			return n, err
+		}
+	}
+}
+
+// scan returns a function fetching the next page of stored keys matching
+// match, along with whether the scan is complete.
+func (c *CacheBaseRedis) scan(ctx context.Context, match string) func() ([]string, bool, error) {
+	cursor := "0"
+	var addrs []string
+	node := 0
+	return func() ([]string, bool, error) {
+		if c.Cluster != nil && addrs == nil {
+			err := c.Cluster.EachNode(false, func(addr string, _ redigo.Conn) error {
+				addrs = append(addrs, addr)
+				return nil
+			})
+			if err != nil {
+				return nil, true, err
+			}
+		}
+		var addr string
+		if addrs != nil {
+			addr = addrs[node]
+		}
+		next, keys, err := c.scanPage(ctx, addr, cursor, match)
+		if err != nil {
+			return nil, true, err
+		}
+		cursor = next
+		if cursor == "0" {
+			node++
+		}
+		return keys, cursor == "0" && node >= len(addrs), nil
+	}
+}
+
+// scanPage runs one SCAN, on the cluster node addr when clustered.
+func (c *CacheBaseRedis) scanPage(ctx context.Context, addr, cursor, match string) (string, []string, error) {
+	if c.Cluster == nil {
+		r := c.get(ctx)
+		defer r.Close()
+		return scanReply(r.Do("SCAN", cursor, "MATCH", match, "COUNT", scanCount))
+	}
+	if err := ctx.Err(); err != nil {
+		return "", nil, wrapError(err)
+	}
+	// a node that left the cluster meanwhile has nothing left to scan
+	next, keys := "0", []string(nil)
+	err := c.Cluster.EachNode(false, func(node string, conn redigo.Conn) error {
+		if node != addr {
+			return nil
+		}
+		var err error
+		next, keys, err = scanReply(conn.Do("SCAN", cursor, "MATCH", match, "COUNT", scanCount))
+		return err
+	})
+	return next, keys, wrapError(err)
+}
+
+func scanReply(reply interface{}, err error) (string, []string, error) {
+	values, err := redigo.Values(reply, err)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(values) != 2 {
+		return "", nil, errors.New("cache: unexpected scan reply")
+	}
+	cursor, err := redigo.String(values[0], nil)
+	if err != nil {
+		return "", nil, err
+	}
+	keys, err := redigo.Strings(values[1], nil)
+	return cursor, keys, err
+}
+
+/*
+memory
+*/
+
+// Scan iterates a snapshot of the live keys matching the glob pattern.
+func (c *CacheBaseMemory) Scan(ctx context.Context, pattern string) *KeyIterator {
+	if pattern == "" {
+		pattern = "*"
+	}
+	re, err := globRegexp(pattern)
+	if err != nil {
+		return &KeyIterator{err: err}
+	}
+	now := time.Now()
+	var keys []string
+	c.mu.Lock()
+	for key, el := range c.items {
+		if !el.Value.(*memoryEntry).expired(now) && re.MatchString(key) {
+			keys = append(keys, key)
+		}
+	}
+	c.mu.Unlock()
+	return &KeyIterator{keys: keys, done: true}
+}
+
+// Flush empties the cache when namespace is its own, a memory cache holds no
+// other namespace.
+func (c *CacheBaseMemory) Flush(ctx context.Context, namespace string) (int64, error) {
+	if namespace == "" {
+		return 0, ErrNoNamespace
+	}
+	if namespace != c.namespace {
+		return 0, nil
+	}
+	c.mu.Lock()
+	n := int64(len(c.items))
+	c.mu.Unlock()
+	c.flush()
+	return n, nil
+}
+
+// globRegexp compiles a redis glob pattern, supporting *, ?, [...] and \
+// escapes.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString(`(?s)^`)
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			b.WriteString(`.*`)
+		case '?':
+			b.WriteString(`.`)
+		case '[':
+			j := strings.IndexByte(pattern[i+1:], ']')
+			if j < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+j]
+			if strings.HasPrefix(class, "^") {
+				class = "^" + regexp.QuoteMeta(class[1:])
+			} else {
+				class = regexp.QuoteMeta(class)
+			}
+			b.WriteString(`[` + class + `]`)
+			i += j + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	b.WriteString(`$`)
+	return regexp.Compile(b.String())
+}
+
+/*
+near
+*/
+
+func (c *CacheBaseNear) Scan(ctx context.Context, pattern string) *KeyIterator {
+	return c.Remote.Scan(ctx, pattern)
+}
+
+// Flush deletes namespace in redis, peers drop their local copy when it is
+// the namespace of the cache.
+func (c *CacheBaseNear) Flush(ctx context.Context, namespace string) (int64, error) {
+	n, err := c.Remote.Flush(ctx, namespace)
+	if namespace == c.Remote.namespace {
+		c.flushLocal()
+		c.publishFlush(ctx)
+	}
+	return n, err
+}
+
+/*
+namespace helpers for caller
+*/
+
+func Scan(ctx context.Context, pattern string) *KeyIterator {
+	return cache.Scan(ctx, pattern)
+}
+
+func Flush(ctx context.Context, namespace string) (int64, error) {
+	return cache.Flush(ctx, namespace)
+}
@@ -0,0 +1,428 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+const (
+	defaultLocalTTL            = 30 * time.Second
+	defaultLocalEntries        = 10000
+	defaultInvalidationChannel = "kit:cache:invalidate"
+	reconnectDelay             = time.Second
+	// generations of the local keys, shared by the keys of a stripe
+	nearStripes = 64
+)
+
+// CacheBaseNear keeps a bounded local copy of hot keys in front of redis.
+// Writes are published on a redis channel so that peers drop their copy.
+type CacheBaseNear struct {
+	Local    *CacheBaseMemory
+	Remote   *CacheBaseRedis
+	id       string
+	channel  string
+	localTTL time.Duration
+	hits     uint64
+	misses   uint64
+	// bumped by every local change, a value read from redis is only kept
+	// when its key did not change during the read
+	mu        sync.Mutex
+	gens      [nearStripes]uint64
+	flushes   uint64
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+type NearStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// new cache base redis with a local near cache, bounded to 10000 entries
+// unless MaxEntries or MaxBytes is set
+func NewCacheBaseNear(opt CacheOption) Cache {
+	local := opt
+	if local.MaxEntries <= 0 && local.MaxBytes <= 0 {
+		local.MaxEntries = defaultLocalEntries
+	}
+	c := &CacheBaseNear{
+		Local:    NewCacheBaseMemory(local).(*CacheBaseMemory),
+		Remote:   NewCacheBaseRedis(opt).(*CacheBaseRedis),
+		id:       newInstanceID(),
+		channel:  opt.InvalidationChannel,
+		localTTL: opt.LocalTTL,
+		stop:     make(chan struct{}),
+	}
+	if c.channel == "" {
+		// peers of other namespaces must not flush this one
+		c.channel = c.Remote.Key(defaultInvalidationChannel)
+	}
+	if c.localTTL <= 0 {
+		c.localTTL = defaultLocalTTL
+	}
+	go c.subscribe()
+	return c
+}
+
+func newInstanceID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Close stops the invalidation subscriber and the local janitor.
+func (c *CacheBaseNear) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		c.Local.Close()
+	})
+	return nil
+}
+
+// Stats returns the local hit and miss counters.
+func (c *CacheBaseNear) Stats() NearStats {
+	return NearStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+	}
+}
+
+func (c *CacheBaseNear) subscribe() {
+	for {
+		c.listen()
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// listen consumes invalidations until the connection breaks or the cache is
+// closed. Messages may have been lost while disconnected, so the local copy
+// is dropped whenever a subscription is (re)established.
+func (c *CacheBaseNear) listen() {
+	conn, err := c.Remote.dedicated()
+	if err != nil {
+		return
+	}
+	psc := redigo.PubSubConn{Conn: conn}
+	defer psc.Close()
+	if err := psc.Subscribe(c.channel); err != nil {
+		return
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	// the unsubscribe must not write while psc is being closed
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+	go func() {
+		defer close(stopped)
+		select {
+		case <-c.stop:
+			psc.Unsubscribe()
+		case <-done:
+		}
+	}()
+	for {
+		switch v := psc.Receive().(type) {
+		case redigo.Message:
+			c.invalidate(string(v.Data))
+		case redigo.Subscription:
+			if v.Kind == "subscribe" {
+				c.flushLocal()
+			}
+			if v.Count == 0 {
+				return
+			}
+		case error:
+			return
+		}
+	}
+}
+
+// invalidate handles "<id> <key>" dropping key and "<id>" dropping every
+// key of the namespace.
+func (c *CacheBaseNear) invalidate(payload string) {
+	i := strings.IndexByte(payload, ' ')
+	switch {
+	case i < 0:
+		if payload != c.id {
+			c.flushLocal()
+		}
+	case payload[:i] != c.id:
+		c.forget(payload[i+1:])
+	}
+}
+
+func stripe(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % nearStripes)
+}
+
+// generation changes whenever key is stored, removed or flushed locally.
+func (c *CacheBaseNear) generation(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flushes + c.gens[stripe(key)]
+}
+
+func (c *CacheBaseNear) store(key string, value interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gens[stripe(key)]++
+	c.Local.setTTL(key, value, ttl)
+}
+
+// storeFetched keeps a value read from redis for ttl unless key changed
+// since gen, the value may be older than the change.
+func (c *CacheBaseNear) storeFetched(key string, value interface{}, ttl time.Duration, gen uint64) {
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.flushes+c.gens[stripe(key)] == gen {
+		c.Local.setTTL(key, value, ttl)
+	}
+}
+
+// fetch reads keys from redis with the local ttl of each, capped at the
+// remote one. Off a cluster it takes one round trip.
+func (c *CacheBaseNear) fetch(ctx context.Context, keys []string) ([]interface{}, []time.Duration, error) {
+	replies, err := c.Remote.Pipeline(ctx, func(b *Batch) {
+		for _, key := range keys {
+			name := c.Remote.Key(key)
+			b.Send("GET", name)
+			b.Send("PTTL", name)
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	values := make([]interface{}, len(keys))
+	ttls := make([]time.Duration, len(keys))
+	for i := range keys {
+		get, pttl := replies[2*i], replies[2*i+1]
+		if get.Err != nil {
+			return nil, nil, get.Err
+		}
+		values[i], ttls[i] = get.Value, c.localTTL
+		// -1 means no expiry, -2 a key gone since the GET
+		if ms, ok := pttl.Value.(int64); ok && ms != -1 {
+			if remote := time.Duration(ms) * time.Millisecond; remote < ttls[i] {
+				ttls[i] = remote
+			}
+		}
+	}
+	return values, ttls, nil
+}
+
+func (c *CacheBaseNear) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gens[stripe(key)]++
+	c.Local.remove(key)
+}
+
+func (c *CacheBaseNear) flushLocal() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushes++
+	c.Local.flush()
+}
+
+func (c *CacheBaseNear) publish(ctx context.Context, key string) {
+	r := c.Remote.get(ctx)
+	defer r.Close()
+	r.Do("PUBLISH", c.channel, c.id+" "+key)
+}
+
+func (c *CacheBaseNear) publishFlush(ctx context.Context) {
+	r := c.Remote.get(ctx)
+	defer r.Close()
+	r.Do("PUBLISH", c.channel, c.id)
+}
+
+func (c *CacheBaseNear) Get(ctx context.Context, key string) (interface{}, error) {
+	if val, _ := c.Local.Get(ctx, key); val != nil {
+		atomic.AddUint64(&c.hits, 1)
+		return val, nil
+	}
+	atomic.AddUint64(&c.misses, 1)
+	gen := c.generation(key)
+	values, ttls, err := c.fetch(ctx, []string{key})
+	if err != nil || values[0] == nil {
+		return nil, err
+	}
+	c.storeFetched(key, values[0], ttls[0], gen)
+	return values[0], nil
+}
+
+func (c *CacheBaseNear) Set(ctx context.Context, key string, value interface{}) (interface{}, error) {
+	res, err := c.Remote.Set(ctx, key, value)
+	if err != nil {
+		c.forget(key)
+		return res, err
+	}
+	c.store(key, value, c.localTTL)
+	c.publish(ctx, key)
+	return res, err
+}
+
+func (c *CacheBaseNear) SetEx(ctx context.Context, key string, value interface{}, sec int) (interface{}, error) {
+	res, err := c.Remote.SetEx(ctx, key, value, sec)
+	if err != nil {
+		c.forget(key)
+		return res, err
+	}
+	ttl := c.localTTL
+	if remote := time.Duration(sec) * time.Second; remote < ttl {
+		ttl = remote
+	}
+	c.store(key, value, ttl)
+	c.publish(ctx, key)
+	return res, err
+}
+
+func (c *CacheBaseNear) Overdue(ctx context.Context, key interface{}) bool {
+	return c.Remote.Overdue(ctx, key)
+}
+
+func (c *CacheBaseNear) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	values, _ := c.Local.MGet(ctx, keys...)
+	var missing []string
+	for i, val := range values {
+		if val == nil {
+			missing = append(missing, keys[i])
+		}
+	}
+	atomic.AddUint64(&c.hits, uint64(len(keys)-len(missing)))
+	atomic.AddUint64(&c.misses, uint64(len(missing)))
+	if len(missing) == 0 {
+		return values, nil
+	}
+	gens := make([]uint64, len(missing))
+	for i, key := range missing {
+		gens[i] = c.generation(key)
+	}
+	remote, ttls, err := c.fetch(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	j := 0
+	for i := range values {
+		if values[i] != nil {
+			continue
+		}
+		values[i] = remote[j]
+		if remote[j] != nil {
+			c.storeFetched(keys[i], remote[j], ttls[j], gens[j])
+		}
+		j++
+	}
+	return values, nil
+}
+
+func (c *CacheBaseNear) MSet(ctx context.Context, values map[string]interface{}) error {
+	err := c.Remote.MSet(ctx, values)
+	c.storeMany(ctx, values, c.localTTL, err)
+	return err
+}
+
+func (c *CacheBaseNear) MSetEx(ctx context.Context, values map[string]interface{}, sec int) error {
+	err := c.Remote.MSetEx(ctx, values, sec)
+	ttl := c.localTTL
+	if remote := time.Duration(sec) * time.Second; remote < ttl {
+		ttl = remote
+	}
+	c.storeMany(ctx, values, ttl, err)
+	return err
+}
+
+// storeMany mirrors a batch write locally, keys that may not have reached
+// redis are dropped instead.
+func (c *CacheBaseNear) storeMany(ctx context.Context, values map[string]interface{}, ttl time.Duration, err error) {
+	failed, partial := err.(BatchError)
+	for key, value := range values {
+		if err != nil && (!partial || failed[key] != nil) {
+			c.forget(key)
+			continue
+		}
+		c.store(key, value, ttl)
+		c.publish(ctx, key)
+	}
+}
+
+func (c *CacheBaseNear) DeleteMany(ctx context.Context, keys ...string) (int64, error) {
+	n, err := c.Remote.DeleteMany(ctx, keys...)
+	for _, key := range keys {
+		c.drop(ctx, key)
+	}
+	return n, err
+}
+
+func (c *CacheBaseNear) Delete(ctx context.Context, key string) (bool, error) {
+	ok, err := c.Remote.Delete(ctx, key)
+	c.drop(ctx, key)
+	return ok, err
+}
+
+func (c *CacheBaseNear) Exists(ctx context.Context, key string) (bool, error) {
+	return c.Remote.Exists(ctx, key)
+}
+
+func (c *CacheBaseNear) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return c.Remote.TTL(ctx, key)
+}
+
+func (c *CacheBaseNear) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	ok, err := c.Remote.Expire(ctx, key, ttl)
+	c.drop(ctx, key)
+	return ok, err
+}
+
+func (c *CacheBaseNear) ExpireAt(ctx context.Context, key string, at time.Time) (bool, error) {
+	ok, err := c.Remote.ExpireAt(ctx, key, at)
+	c.drop(ctx, key)
+	return ok, err
+}
+
+func (c *CacheBaseNear) Persist(ctx context.Context, key string) (bool, error) {
+	return c.Remote.Persist(ctx, key)
+}
+
+func (c *CacheBaseNear) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return c.IncrBy(ctx, key, 1, ttl)
+}
+
+func (c *CacheBaseNear) IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	v, err := c.Remote.IncrBy(ctx, key, n, ttl)
+	c.drop(ctx, key)
+	return v, err
+}
+
+func (c *CacheBaseNear) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	ok, err := c.Remote.SetNX(ctx, key, value, ttl)
+	if ok {
+		c.drop(ctx, key)
+	}
+	return ok, err
+}
+
+// drop removes key locally and from every peer.
+func (c *CacheBaseNear) drop(ctx context.Context, key string) {
+	c.forget(key)
+	c.publish(ctx, key)
+}
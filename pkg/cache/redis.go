@@ -5,7 +5,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/aivencs/kit/pkg/internal/redisconn"
+	"github.com/aivencs/kit/pkg/metrics"
 	redigo "github.com/gomodule/redigo/redis"
+	"github.com/mna/redisc"
 )
 
 var (
@@ -27,17 +30,82 @@ type CacheOption struct {
 	MaxIdle     int
 	IdleTimeout time.Duration
 	MaxActive   int
+	// default bound of an operation whose ctx has no deadline, zero waits
+	// forever
+	CommandTimeout time.Duration
+	DialTimeout    time.Duration
+	// registered codec of the typed helpers, json when empty
+	Codec string
+	Load  LoadOption
+	// keys are stored as <Namespace>:<key>, Namespace defaults to
+	// <Database>:<Table>
+	Namespace  string
+	KeyBuilder KeyBuilder
+	// compression and encryption of values
+	Transform TransformOption
+	// fail fast, or degrade to memory, while redis is unavailable
+	Breaker BreakerOption
+	// operations and pool stats are reported when set
+	Collector metrics.Collector
+	// redis://[user:password@]host:port/db, or rediss:// over tls, replaces
+	// Host and takes precedence over the credentials and DB
+	URL string
+	// tls, the files are pem encoded
+	TLS           bool
+	TLSCAFile     string
+	TLSCertFile   string
+	TLSKeyFile    string
+	TLSServerName string
+	TLSSkipVerify bool
+	// sentinel
+	MasterName    string
+	SentinelAddrs []string
+	// cluster
+	ClusterNodes []string
+	// memory, breaker fallback and the local copy of near
+	MaxEntries      int
+	MaxBytes        int64
+	CleanupInterval time.Duration
+	// near only, the local copy defaults to 10000 entries when neither
+	// MaxEntries nor MaxBytes is set
+	LocalTTL            time.Duration
+	InvalidationChannel string
 }
 
 type Cache interface {
 	Get(ctx context.Context, key string) (interface{}, error)
 	Set(ctx context.Context, key string, value interface{}) (interface{}, error)
+	// Deprecated: Overdue cannot tell a missing key from one without expiry
+	// and hides errors, use TTL.
 	Overdue(ctx context.Context, key interface{}) bool
 	SetEx(ctx context.Context, key string, value interface{}, sec int) (interface{}, error)
+	SetWithTags(ctx context.Context, key string, value interface{}, tags ...string) (interface{}, error)
+	SetExWithTags(ctx context.Context, key string, value interface{}, sec int, tags ...string) (interface{}, error)
+	InvalidateTags(ctx context.Context, tags ...string) (int64, error)
+	Delete(ctx context.Context, key string) (bool, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	TTL(ctx context.Context, key string) (time.Duration, error)
+	Expire(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	ExpireAt(ctx context.Context, key string, at time.Time) (bool, error)
+	Persist(ctx context.Context, key string) (bool, error)
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error)
+	SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error)
+	MGet(ctx context.Context, keys ...string) ([]interface{}, error)
+	MSet(ctx context.Context, values map[string]interface{}) error
+	MSetEx(ctx context.Context, values map[string]interface{}, sec int) error
+	DeleteMany(ctx context.Context, keys ...string) (int64, error)
+	Scan(ctx context.Context, pattern string) *KeyIterator
+	Flush(ctx context.Context, namespace string) (int64, error)
 }
 
 type CacheBaseRedis struct {
-	Pool *redigo.Pool
+	Pool      *redigo.Pool
+	Cluster   *redisc.Cluster
+	timeout   time.Duration
+	namespace string
+	build     KeyBuilder
+	db        int
 }
 
 func InitCache(name string, opt CacheOption) {
@@ -45,47 +113,104 @@ func InitCache(name string, opt CacheOption) {
 		switch name {
 		case "redis":
 			cache = NewCacheBaseRedis(opt)
+		case "memory":
+			cache = NewCacheBaseMemory(opt)
+		case "near":
+			cache = NewCacheBaseNear(opt)
 		default:
 			cache = NewCacheBaseRedis(opt)
 		}
+		if opt.Breaker.enabled() {
+			var fallback Cache
+			if opt.Breaker.Fallback {
+				fallback = NewCacheBaseMemory(opt)
+			}
+			cache = NewBreakerCache(cache, fallback, opt.Breaker)
+		}
+		if opt.Transform.enabled() {
+			cache = NewTransformCache(cache, opt.Transform)
+		}
+		if opt.Collector != nil {
+			cache = NewMeteredCache(cache, opt.Collector)
+		}
+		typed = NewTypedCache(cache, GetCodec(opt.Codec))
+		loader = NewCacheLoader(cache, opt.Load)
 	})
 }
 
-// new cache base redis
+// new cache base redis, a cluster when ClusterNodes is set and a sentinel
+// monitored master when MasterName is set
 func NewCacheBaseRedis(opt CacheOption) Cache {
 	applyOption(opt)
-	pool := &redigo.Pool{
-		MaxIdle:     maxIdle,
-		IdleTimeout: idleTimeout,
-		MaxActive:   maxActive,
-		Wait:        true,
-		Dial: func() (redigo.Conn, error) {
-			c, err := redigo.Dial("tcp", opt.Host)
-			if err != nil {
-				return nil, err
-			}
-			if opt.Auth {
-				if _, err := c.Do("AUTH", opt.Password); err != nil {
-					c.Close()
-					return nil, err
-				}
-				if _, err := c.Do("SELECT", opt.DB); err != nil {
-					c.Close()
-					return nil, err
-				}
-			}
-			return c, err
-		},
-		TestOnBorrow: func(c redigo.Conn, t time.Time) error {
-			_, err := c.Do("PING")
-			return err
-		},
+	build := opt.KeyBuilder
+	if build == nil {
+		build = DefaultKeyBuilder
+	}
+	if len(opt.ClusterNodes) > 0 {
+		return &CacheBaseRedis{
+			Cluster:   redisconn.NewCluster(connOption(opt)),
+			timeout:   opt.CommandTimeout,
+			namespace: namespaceOf(opt),
+			build:     build,
+		}
 	}
 	return &CacheBaseRedis{
-		Pool: pool,
+		Pool:      redisconn.NewPool(connOption(opt)),
+		timeout:   opt.CommandTimeout,
+		namespace: namespaceOf(opt),
+		build:     build,
+		db:        redisconn.DB(connOption(opt)),
+	}
+}
+
+func connOption(opt CacheOption) redisconn.Option {
+	return redisconn.Option{
+		Name:           "cache",
+		Host:           opt.Host,
+		Auth:           opt.Auth,
+		Username:       opt.Username,
+		Password:       opt.Password,
+		DB:             opt.DB,
+		URL:            opt.URL,
+		CommandTimeout: opt.CommandTimeout,
+		DialTimeout:    opt.DialTimeout,
+		TLS:            opt.TLS,
+		TLSCAFile:      opt.TLSCAFile,
+		TLSCertFile:    opt.TLSCertFile,
+		TLSKeyFile:     opt.TLSKeyFile,
+		TLSServerName:  opt.TLSServerName,
+		TLSSkipVerify:  opt.TLSSkipVerify,
+		MasterName:     opt.MasterName,
+		SentinelAddrs:  opt.SentinelAddrs,
+		ClusterNodes:   opt.ClusterNodes,
+		MaxIdle:        maxIdle,
+		IdleTimeout:    idleTimeout,
+		MaxActive:      maxActive,
+		Wait:           true,
 	}
 }
 
+// get borrows a connection whose commands run under ctx, bounded by the
+// default command timeout when ctx has no deadline. Cluster connections are
+// bound to the slot of keys and follow MOVED and ASK redirects.
+func (c *CacheBaseRedis) get(ctx context.Context, keys ...string) redigo.Conn {
+	return redisconn.Get(ctx, c.Pool, c.Cluster, c.timeout, keys...)
+}
+
+// Conn borrows a connection for packages building on the cache pool, the
+// caller must close it.
+func (c *CacheBaseRedis) Conn(ctx context.Context, keys ...string) redigo.Conn {
+	return c.get(ctx, keys...)
+}
+
+// dedicated returns a connection that may enter the pubsub state.
+func (c *CacheBaseRedis) dedicated() (redigo.Conn, error) {
+	if c.Cluster == nil {
+		return c.Pool.Get(), nil
+	}
+	return c.Cluster.Dial()
+}
+
 func applyOption(opt CacheOption) {
 	if opt.MaxIdle > 0 {
 		maxIdle = opt.MaxIdle
@@ -99,33 +224,50 @@ func applyOption(opt CacheOption) {
 }
 
 func (c *CacheBaseRedis) Get(ctx context.Context, key string) (interface{}, error) {
-	r := c.Pool.Get()
+	key = c.Key(key)
+	r := c.get(ctx, key)
 	defer r.Close()
 	return r.Do("GET", key)
 }
 
 func (c *CacheBaseRedis) Set(ctx context.Context, key string, value interface{}) (interface{}, error) {
-	r := c.Pool.Get()
+	key = c.Key(key)
+	r := c.get(ctx, key)
 	defer r.Close()
 	return r.Do("SET", key, value)
 }
 
 func (c *CacheBaseRedis) SetEx(ctx context.Context, key string, value interface{}, sec int) (interface{}, error) {
-	r := c.Pool.Get()
+	key = c.Key(key)
+	r := c.get(ctx, key)
 	defer r.Close()
 	return r.Do("SETEX", key, sec, value)
 }
 
 func (c *CacheBaseRedis) Overdue(ctx context.Context, key interface{}) bool {
-	r := c.Pool.Get()
+	name := c.Key(string(formatValue(key)))
+	r := c.get(ctx, name)
 	defer r.Close()
-	res, err := r.Do("TTL", key)
+	res, err := r.Do("TTL", name)
 	if err != nil {
 		return false
 	}
 	return res.(int64) > 1
 }
 
+// Redis returns the redis backend of the package cache, nil for memory.
+func Redis() *CacheBaseRedis {
+	return redisOf(cache)
+}
+
+func redisCache() (*CacheBaseRedis, error) {
+	rc := redisOf(cache)
+	if rc == nil {
+		return nil, ErrUnsupported
+	}
+	return rc, nil
+}
+
 func Get(ctx context.Context, key string) (interface{}, error) {
 	return cache.Get(ctx, key)
 }
@@ -138,6 +280,7 @@ func SetEx(ctx context.Context, key string, value interface{}, sec int) (interfa
 	return cache.SetEx(ctx, key, value, sec)
 }
 
+// Deprecated: use TTL.
 func Overdue(ctx context.Context, key interface{}) bool {
 	return cache.Overdue(ctx, key)
 }
@@ -0,0 +1,255 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+const (
+	snapshotVersion = 1
+	restoreBatch    = 100
+)
+
+type SnapshotOption struct {
+	// called with the number of keys exported or imported so far
+	Progress func(done int64)
+	// count the keys without writing the snapshot or restoring them
+	DryRun bool
+	// overwrite existing keys on import, they are skipped otherwise
+	Replace bool
+}
+
+// snapshotHeader is the first line of a snapshot.
+type snapshotHeader struct {
+	Version int `json:"version"`
+}
+
+// snapshotEntry is one line per key. Key has no namespace and Dump is the
+// DUMP serialization, which RESTORE only accepts on the same or a newer redis
+// version. ExpireAt is in unix milliseconds, zero without expiry.
+type snapshotEntry struct {
+	Key      string `json:"key"`
+	Dump     []byte `json:"dump"`
+	ExpireAt int64  `json:"expire_at,omitempty"`
+}
+
+// Export writes the keys of the namespace matching pattern to w as JSON
+// lines, and returns how many keys were written. Keys changing during the
+// export may be missed.
+func (c *CacheBaseRedis) Export(ctx context.Context, pattern string, w io.Writer, opt SnapshotOption) (int64, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	if !opt.DryRun {
+		if err := enc.Encode(snapshotHeader{Version: snapshotVersion}); err != nil {
+			return 0, err
+		}
+	}
+	prefix := c.Key("")
+	keys := c.scan(ctx, c.Key(pattern))
+	var n int64
+	for {
+		page, done, err := keys()
+		if err != nil {
+			return n, err
+		}
+		entries, err := c.dump(ctx, page)
+		if err != nil {
+			return n, err
+		}
+		for _, entry := range entries {
+			entry.Key = strings.TrimPrefix(entry.Key, prefix)
+			if !opt.DryRun {
+				if err := enc.Encode(entry); err != nil {
+					return n, err
+				}
+			}
+			n++
+			if opt.Progress != nil {
+				opt.Progress(n)
+			}
+		}
+		if done {
+			return n, bw.Flush()
+		}
+	}
+}
+
+// dump reads the serialization and expiry of keys, skipping vanished ones.
+func (c *CacheBaseRedis) dump(ctx context.Context, keys []string) ([]snapshotEntry, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	now := time.Now()
+	replies, err := c.Pipeline(ctx, func(b *Batch) {
+		for _, key := range keys {
+			b.Send("PTTL", key)
+			b.Send("DUMP", key)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]snapshotEntry, 0, len(keys))
+	for i, key := range keys {
+		ttl, dump := replies[2*i], replies[2*i+1]
+		if ttl.Err != nil {
+			return nil, ttl.Err
+		}
+		if dump.Err != nil {
+			return nil, dump.Err
+		}
+		ms, _ := ttl.Value.(int64)
+		data, _ := dump.Value.([]byte)
+		if ms == -2 || data == nil {
+			continue
+		}
+		entry := snapshotEntry{Key: key, Dump: data}
+		if ms > 0 {
+			entry.ExpireAt = now.Add(time.Duration(ms) * time.Millisecond).UnixMilli()
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Import restores a snapshot written by Export into the namespace of c, and
+// returns how many keys were restored. Keys that expired since the export
+// are skipped.
+func (c *CacheBaseRedis) Import(ctx context.Context, r io.Reader, opt SnapshotOption) (int64, error) {
+	br := bufio.NewReader(r)
+	line, err := readLine(br)
+	if err != nil {
+		return 0, err
+	}
+	var header snapshotHeader
+	if err := json.Unmarshal(line, &header); err != nil || header.Version != snapshotVersion {
+		return 0, errors.New("cache: not a snapshot written by Export")
+	}
+	var n int64
+	var batch []snapshotEntry
+	flush := func() error {
+		restored, err := c.restore(ctx, batch, opt)
+		for i := int64(0); i < restored; i++ {
+			n++
+			if opt.Progress != nil {
+				opt.Progress(n)
+			}
+		}
+		batch = batch[:0]
+		return err
+	}
+	for lineNo := 2; ; lineNo++ {
+		line, err := readLine(br)
+		if err == io.EOF {
+			return n, flush()
+		}
+		if err != nil {
+			return n, err
+		}
+		var entry snapshotEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return n, fmt.Errorf("cache: snapshot line %d: %w", lineNo, err)
+		}
+		batch = append(batch, entry)
+		if len(batch) == restoreBatch {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+}
+
+// restore returns how many entries were or, on a dry run, would be restored.
+func (c *CacheBaseRedis) restore(ctx context.Context, entries []snapshotEntry, opt SnapshotOption) (int64, error) {
+	now := time.Now().UnixMilli()
+	var live []snapshotEntry
+	for _, entry := range entries {
+		if entry.ExpireAt == 0 || entry.ExpireAt > now {
+			live = append(live, entry)
+		}
+	}
+	if opt.DryRun || len(live) == 0 {
+		return int64(len(live)), nil
+	}
+	replies, err := c.Pipeline(ctx, func(b *Batch) {
+		for _, entry := range live {
+			var ttl int64
+			if entry.ExpireAt > 0 {
+				ttl = entry.ExpireAt - now
+			}
+			args := redigo.Args{c.Key(entry.Key), ttl, entry.Dump}
+			if opt.Replace {
+				args = args.Add("REPLACE")
+			}
+			b.Send("RESTORE", args...)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, reply := range replies {
+		if reply.Err != nil {
+			if strings.HasPrefix(reply.Err.Error(), "BUSYKEY") {
+				continue
+			}
+			return n, reply.Err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// readLine returns the next non-empty line without its newline.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 && err == io.EOF {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line = trimNewline(line); len(line) > 0 {
+			return line, nil
+		}
+	}
+}
+
+func trimNewline(line []byte) []byte {
+	for len(line) > 0 && (line[len(line)-1] == '\n' || line[len(line)-1] == '\r') {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+/*
+snapshot helpers for caller
+*/
+
+func Export(ctx context.Context, pattern string, w io.Writer, opt SnapshotOption) (int64, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return 0, err
+	}
+	return rc.Export(ctx, pattern, w, opt)
+}
+
+func Import(ctx context.Context, r io.Reader, opt SnapshotOption) (int64, error) {
+	rc, err := redisCache()
+	if err != nil {
+		return 0, err
+	}
+	return rc.Import(ctx, r, opt)
+}
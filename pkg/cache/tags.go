@@ -0,0 +1,232 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+const tagPrefix = "kit:tag:"
+
+var (
+	// tag sets are sorted by the expiry of their keys, so expired members are
+	// pruned on write and the set expires with its last member
+	setTagsScript = redigo.NewScript(-1, `
+redis.replicate_commands()
+local ttl = tonumber(ARGV[2])
+if ttl > 0 then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ttl)
+else
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local score = "+inf"
+if ttl > 0 then
+	score = now + ttl
+end
+for i = 2, #KEYS do
+	redis.call("ZREMRANGEBYSCORE", KEYS[i], "-inf", now)
+	local fresh = redis.call("EXISTS", KEYS[i]) == 0
+	redis.call("ZADD", KEYS[i], score, KEYS[1])
+	if ttl == 0 then
+		redis.call("PERSIST", KEYS[i])
+	else
+		local pttl = redis.call("PTTL", KEYS[i])
+		if fresh or (pttl ~= -1 and pttl < ttl) then
+			redis.call("PEXPIRE", KEYS[i], ttl)
+		end
+	end
+end
+return "OK"`)
+
+	invalidateTagsScript = redigo.NewScript(-1, `
+redis.replicate_commands()
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local deleted = {}
+for i = 1, #KEYS do
+	local members = redis.call("ZRANGEBYSCORE", KEYS[i], now, "+inf")
+	for _, key in ipairs(members) do
+		if redis.call("DEL", key) == 1 then
+			table.insert(deleted, key)
+		end
+	end
+	redis.call("DEL", KEYS[i])
+end
+return deleted`)
+)
+
+/*
+redis
+*/
+
+// SetWithTags sets key and attaches it to tags, so that InvalidateTags on any
+// of them deletes it. On a cluster the key and its tags must share a hash
+// slot, e.g. through a {hash tag}.
+func (c *CacheBaseRedis) SetWithTags(ctx context.Context, key string, value interface{}, tags ...string) (interface{}, error) {
+	return c.setTags(ctx, key, value, 0, tags)
+}
+
+func (c *CacheBaseRedis) SetExWithTags(ctx context.Context, key string, value interface{}, sec int, tags ...string) (interface{}, error) {
+	if sec <= 0 {
+		return nil, redigo.Error("ERR invalid expire time in 'setex' command")
+	}
+	return c.setTags(ctx, key, value, time.Duration(sec)*time.Second, tags)
+}
+
+func (c *CacheBaseRedis) setTags(ctx context.Context, key string, value interface{}, ttl time.Duration, tags []string) (interface{}, error) {
+	key = c.Key(key)
+	args := redigo.Args{len(tags) + 1, key}
+	for _, tag := range tags {
+		args = args.Add(c.Key(tagPrefix + tag))
+	}
+	r := c.get(ctx, key)
+	defer r.Close()
+	return setTagsScript.Do(r, args.Add(value, ttl.Milliseconds())...)
+}
+
+// InvalidateTags atomically deletes every key attached to one of tags along
+// with the tags, and returns how many keys were deleted.
+func (c *CacheBaseRedis) InvalidateTags(ctx context.Context, tags ...string) (int64, error) {
+	keys, err := c.invalidateTags(ctx, tags)
+	return int64(len(keys)), err
+}
+
+// invalidateTags returns the deleted keys without namespace.
+func (c *CacheBaseRedis) invalidateTags(ctx context.Context, tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	args := redigo.Args{len(tags)}
+	for _, tag := range tags {
+		args = args.Add(c.Key(tagPrefix + tag))
+	}
+	r := c.get(ctx, args[1].(string))
+	defer r.Close()
+	keys, err := redigo.Strings(invalidateTagsScript.Do(r, args...))
+	prefix := c.Key("")
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, prefix)
+	}
+	return keys, err
+}
+
+/*
+memory
+*/
+
+func (c *CacheBaseMemory) SetWithTags(ctx context.Context, key string, value interface{}, tags ...string) (interface{}, error) {
+	res, err := c.Set(ctx, key, value)
+	c.tag(key, tags)
+	return res, err
+}
+
+func (c *CacheBaseMemory) SetExWithTags(ctx context.Context, key string, value interface{}, sec int, tags ...string) (interface{}, error) {
+	res, err := c.SetEx(ctx, key, value, sec)
+	if err == nil {
+		c.tag(key, tags)
+	}
+	return res, err
+}
+
+func (c *CacheBaseMemory) tag(key string, tags []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tags == nil {
+		c.tags = make(map[string]map[string]struct{})
+	}
+	for _, tag := range tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = make(map[string]struct{})
+		}
+		c.tags[tag][key] = struct{}{}
+	}
+}
+
+func (c *CacheBaseMemory) InvalidateTags(ctx context.Context, tags ...string) (int64, error) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int64
+	for _, tag := range tags {
+		for key := range c.tags[tag] {
+			if el := c.lookup(key, now); el != nil {
+				c.removeElement(el)
+				n++
+			}
+		}
+		delete(c.tags, tag)
+	}
+	return n, nil
+}
+
+// pruneTags forgets keys that are gone. caller must hold c.mu.
+func (c *CacheBaseMemory) pruneTags() {
+	for tag, keys := range c.tags {
+		for key := range keys {
+			if _, ok := c.items[key]; !ok {
+				delete(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
+/*
+near
+*/
+
+func (c *CacheBaseNear) SetWithTags(ctx context.Context, key string, value interface{}, tags ...string) (interface{}, error) {
+	res, err := c.Remote.SetWithTags(ctx, key, value, tags...)
+	if err != nil {
+		c.forget(key)
+		return res, err
+	}
+	c.store(key, value, c.localTTL)
+	c.publish(ctx, key)
+	return res, err
+}
+
+func (c *CacheBaseNear) SetExWithTags(ctx context.Context, key string, value interface{}, sec int, tags ...string) (interface{}, error) {
+	res, err := c.Remote.SetExWithTags(ctx, key, value, sec, tags...)
+	if err != nil {
+		c.forget(key)
+		return res, err
+	}
+	ttl := c.localTTL
+	if remote := time.Duration(sec) * time.Second; remote < ttl {
+		ttl = remote
+	}
+	c.store(key, value, ttl)
+	c.publish(ctx, key)
+	return res, err
+}
+
+func (c *CacheBaseNear) InvalidateTags(ctx context.Context, tags ...string) (int64, error) {
+	keys, err := c.Remote.invalidateTags(ctx, tags)
+	for _, key := range keys {
+		c.drop(ctx, key)
+	}
+	return int64(len(keys)), err
+}
+
+/*
+tag helpers for caller
+*/
+
+func SetWithTags(ctx context.Context, key string, value interface{}, tags ...string) (interface{}, error) {
+	return cache.SetWithTags(ctx, key, value, tags...)
+}
+
+func SetExWithTags(ctx context.Context, key string, value interface{}, sec int, tags ...string) (interface{}, error) {
+	return cache.SetExWithTags(ctx, key, value, sec, tags...)
+}
+
+func InvalidateTags(ctx context.Context, tags ...string) (int64, error) {
+	return cache.InvalidateTags(ctx, tags...)
+}
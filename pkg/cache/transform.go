@@ -0,0 +1,313 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// transformed values start with a byte msgpack never writes, followed by
+	// the flags and, when encrypted, the key id
+	transformMarker   byte = 0xc1
+	compressionMask   byte = 0x03
+	flagGzip          byte = 0x01
+	flagZstd          byte = 0x02
+	flagSnappy        byte = 0x03
+	flagEncrypted     byte = 0x04
+	defaultCompressAt      = 1024
+)
+
+var (
+	ErrDecrypt    = errors.New("cache: value cannot be decrypted")
+	ErrUnknownKey = errors.New("cache: value is encrypted with an unknown key")
+
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+type TransformOption struct {
+	// gzip, zstd or snappy, empty disables compression
+	Compression string
+	// smaller values are stored uncompressed, defaults to 1KB
+	CompressThreshold int
+	// aes keys of 16, 24 or 32 bytes by id, encryption is disabled when
+	// empty. Retired keys should stay until the values they encrypted expire.
+	Keys map[byte][]byte
+	// id of the key encrypting new values
+	KeyID byte
+}
+
+func (opt TransformOption) enabled() bool {
+	return opt.Compression != "" || len(opt.Keys) > 0
+}
+
+// TransformCache compresses and encrypts the values written through it and
+// reverses it on read. Values written without transformation, or with other
+// options, are still read back. Counters are not transformed.
+type TransformCache struct {
+	Cache
+	compression byte
+	threshold   int
+	aeads       map[byte]cipher.AEAD
+	keyID       byte
+	// configuration error returned by every write
+	err error
+}
+
+// new transform cache
+func NewTransformCache(c Cache, opt TransformOption) Cache {
+	t := &TransformCache{
+		Cache:     c,
+		threshold: opt.CompressThreshold,
+	}
+	if t.threshold <= 0 {
+		t.threshold = defaultCompressAt
+	}
+	switch opt.Compression {
+	case "":
+	case "gzip":
+		t.compression = flagGzip
+	case "zstd":
+		t.compression = flagZstd
+	case "snappy":
+		t.compression = flagSnappy
+	default:
+		t.err = fmt.Errorf("cache: unknown compression %q", opt.Compression)
+	}
+	if len(opt.Keys) > 0 {
+		t.aeads = make(map[byte]cipher.AEAD, len(opt.Keys))
+		for id, key := range opt.Keys {
+			block, err := aes.NewCipher(key)
+			if err != nil {
+				t.err = err
+				break
+			}
+			if t.aeads[id], err = cipher.NewGCM(block); err != nil {
+				t.err = err
+				break
+			}
+		}
+		if _, ok := t.aeads[opt.KeyID]; !ok && t.err == nil {
+			t.err = fmt.Errorf("cache: encryption key %d is not configured", opt.KeyID)
+		}
+		t.keyID = opt.KeyID
+	}
+	return t
+}
+
+// encode transforms value stored under key, the key is authenticated with
+// the header so an encrypted value cannot be moved to another key.
+func (c *TransformCache) encode(key string, value interface{}) ([]byte, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	data := formatValue(value)
+	var flags byte
+	if c.compression != 0 && len(data) >= c.threshold {
+		compressed, err := compress(c.compression, data)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(data) {
+			data, flags = compressed, c.compression
+		}
+	}
+	if c.aeads == nil {
+		// plain values only need a header when they look transformed
+		if flags == 0 && (len(data) == 0 || data[0] != transformMarker) {
+			return data, nil
+		}
+		return append([]byte{transformMarker, flags}, data...), nil
+	}
+	aead := c.aeads[c.keyID]
+	header := []byte{transformMarker, flags | flagEncrypted, c.keyID}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := append(header, nonce...)
+	return aead.Seal(out, nonce, data, aad(header, key)), nil
+}
+
+// decode returns raw unchanged when it does not carry a valid header.
+func (c *TransformCache) decode(key string, raw interface{}) (interface{}, error) {
+	b, ok := raw.([]byte)
+	if !ok || len(b) < 2 || b[0] != transformMarker || b[1]&^(compressionMask|flagEncrypted) != 0 {
+		return raw, nil
+	}
+	flags, data := b[1], b[2:]
+	if flags&flagEncrypted != 0 {
+		if len(data) == 0 {
+			return nil, ErrDecrypt
+		}
+		aead, ok := c.aeads[data[0]]
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		size := aead.NonceSize()
+		if len(data) < 1+size {
+			return nil, ErrDecrypt
+		}
+		plain, err := aead.Open(nil, data[1:1+size], data[1+size:], aad(b[:3], key))
+		if err != nil {
+			return nil, ErrDecrypt
+		}
+		data = plain
+	}
+	if flags&compressionMask != 0 {
+		return decompress(flags&compressionMask, data)
+	}
+	return data, nil
+}
+
+func aad(header []byte, key string) []byte {
+	return append(header[:len(header):len(header)], key...)
+}
+
+func compress(algorithm byte, data []byte) ([]byte, error) {
+	switch algorithm {
+	case flagGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case flagZstd:
+		initZstd()
+		return zstdEncoder.EncodeAll(data, nil), nil
+	default:
+		return s2.EncodeSnappy(nil, data), nil
+	}
+}
+
+func decompress(algorithm byte, data []byte) ([]byte, error) {
+	switch algorithm {
+	case flagGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case flagZstd:
+		initZstd()
+		return zstdDecoder.DecodeAll(data, nil)
+	default:
+		return s2.Decode(nil, data)
+	}
+}
+
+// initZstd builds the shared coders, both are safe for concurrent use.
+func initZstd() {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil)
+		zstdDecoder, _ = zstd.NewReader(nil)
+	})
+}
+
+func (c *TransformCache) encodeMany(values map[string]interface{}) (map[string]interface{}, error) {
+	encoded := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		data, err := c.encode(key, value)
+		if err != nil {
+			return nil, err
+		}
+		encoded[key] = data
+	}
+	return encoded, nil
+}
+
+func (c *TransformCache) Get(ctx context.Context, key string) (interface{}, error) {
+	val, err := c.Cache.Get(ctx, key)
+	if err != nil {
+		return val, err
+	}
+	return c.decode(key, val)
+}
+
+func (c *TransformCache) Set(ctx context.Context, key string, value interface{}) (interface{}, error) {
+	data, err := c.encode(key, value)
+	if err != nil {
+		return nil, err
+	}
+	return c.Cache.Set(ctx, key, data)
+}
+
+func (c *TransformCache) SetEx(ctx context.Context, key string, value interface{}, sec int) (interface{}, error) {
+	data, err := c.encode(key, value)
+	if err != nil {
+		return nil, err
+	}
+	return c.Cache.SetEx(ctx, key, data, sec)
+}
+
+func (c *TransformCache) SetWithTags(ctx context.Context, key string, value interface{}, tags ...string) (interface{}, error) {
+	data, err := c.encode(key, value)
+	if err != nil {
+		return nil, err
+	}
+	return c.Cache.SetWithTags(ctx, key, data, tags...)
+}
+
+func (c *TransformCache) SetExWithTags(ctx context.Context, key string, value interface{}, sec int, tags ...string) (interface{}, error) {
+	data, err := c.encode(key, value)
+	if err != nil {
+		return nil, err
+	}
+	return c.Cache.SetExWithTags(ctx, key, data, sec, tags...)
+}
+
+func (c *TransformCache) SetNX(ctx context.Context, key string, value interface{}, ttl time.Duration) (bool, error) {
+	data, err := c.encode(key, value)
+	if err != nil {
+		return false, err
+	}
+	return c.Cache.SetNX(ctx, key, data, ttl)
+}
+
+// MGet fails as a whole if one of the values cannot be decoded.
+func (c *TransformCache) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	values, err := c.Cache.MGet(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+	for i, val := range values {
+		if values[i], err = c.decode(keys[i], val); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (c *TransformCache) MSet(ctx context.Context, values map[string]interface{}) error {
+	encoded, err := c.encodeMany(values)
+	if err != nil {
+		return err
+	}
+	return c.Cache.MSet(ctx, encoded)
+}
+
+func (c *TransformCache) MSetEx(ctx context.Context, values map[string]interface{}, sec int) error {
+	encoded, err := c.encodeMany(values)
+	if err != nil {
+		return err
+	}
+	return c.Cache.MSetEx(ctx, encoded, sec)
+}
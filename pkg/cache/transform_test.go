@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+var (
+	testKey1 = bytes.Repeat([]byte{1}, 16)
+	testKey2 = bytes.Repeat([]byte{2}, 32)
+)
+
+func newTestTransform(opt TransformOption) (*TransformCache, *CacheBaseMemory) {
+	inner := newTestMemory(CacheOption{})
+	return NewTransformCache(inner, opt).(*TransformCache), inner
+}
+
+func TestTransformRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	large := bytes.Repeat([]byte("compressible "), 200)
+	for _, compression := range []string{"", "gzip", "zstd", "snappy"} {
+		for _, keys := range []map[byte][]byte{nil, {1: testKey1}} {
+			c, inner := newTestTransform(TransformOption{Compression: compression, Keys: keys, KeyID: 1})
+			for _, value := range [][]byte{large, []byte("small"), {}} {
+				if _, err := c.Set(ctx, "k", value); err != nil {
+					t.Fatalf("%s, encrypted %v: Set: %v", compression, keys != nil, err)
+				}
+				got, err := c.Get(ctx, "k")
+				if err != nil || !bytes.Equal(got.([]byte), value) {
+					t.Errorf("%s, encrypted %v: Get = %.20q, %v", compression, keys != nil, got, err)
+				}
+			}
+			c.Set(ctx, "k", large)
+			raw, _ := inner.Get(ctx, "k")
+			stored := raw.([]byte)
+			if compression != "" && len(stored) >= len(large) {
+				t.Errorf("%s: %d bytes stored for %d", compression, len(stored), len(large))
+			}
+			if keys != nil && bytes.Contains(stored, []byte("compressible")) {
+				t.Errorf("%s: the encrypted value holds the plain text", compression)
+			}
+		}
+	}
+}
+
+func TestTransformRetiredKey(t *testing.T) {
+	ctx := context.Background()
+	old, inner := newTestTransform(TransformOption{Keys: map[byte][]byte{1: testKey1}, KeyID: 1})
+	old.Set(ctx, "k", "v")
+	// the key moved to 2, values encrypted with 1 are still read
+	c := NewTransformCache(inner, TransformOption{Keys: map[byte][]byte{1: testKey1, 2: testKey2}, KeyID: 2})
+	if got, err := c.Get(ctx, "k"); err != nil || string(got.([]byte)) != "v" {
+		t.Errorf("Get with a retired key = %s, %v", got, err)
+	}
+	c.Set(ctx, "k", "w")
+	if raw, _ := inner.Get(ctx, "k"); raw.([]byte)[2] != 2 {
+		t.Errorf("new value encrypted with key %d, want 2", raw.([]byte)[2])
+	}
+	// once 1 is dropped, its values cannot be read
+	old.Set(ctx, "k", "v")
+	c = NewTransformCache(inner, TransformOption{Keys: map[byte][]byte{2: testKey2}, KeyID: 2})
+	if _, err := c.Get(ctx, "k"); err != ErrUnknownKey {
+		t.Errorf("Get with a dropped key = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestTransformMovedValue(t *testing.T) {
+	ctx := context.Background()
+	c, inner := newTestTransform(TransformOption{Keys: map[byte][]byte{1: testKey1}, KeyID: 1})
+	c.Set(ctx, "a", "v")
+	raw, _ := inner.Get(ctx, "a")
+	inner.Set(ctx, "b", raw)
+	if _, err := c.Get(ctx, "b"); err != ErrDecrypt {
+		t.Errorf("Get of a value moved to another key = %v, want ErrDecrypt", err)
+	}
+	// so is a tampered one
+	raw.([]byte)[len(raw.([]byte))-1] ^= 1
+	inner.Set(ctx, "a", raw)
+	if _, err := c.Get(ctx, "a"); err != ErrDecrypt {
+		t.Errorf("Get of a tampered value = %v, want ErrDecrypt", err)
+	}
+}
+
+func TestTransformMarkerValues(t *testing.T) {
+	ctx := context.Background()
+	values := [][]byte{{transformMarker}, {transformMarker, 0}, {transformMarker, flagGzip, 'x'}, {transformMarker, 0xff, 'x'}}
+	c, inner := newTestTransform(TransformOption{Compression: "gzip"})
+	for _, value := range values {
+		c.Set(ctx, "k", value)
+		if got, err := c.Get(ctx, "k"); err != nil || !bytes.Equal(got.([]byte), value) {
+			t.Errorf("Get(%q) = %q, %v", value, got, err)
+		}
+	}
+	// plain values written around the cache are read as they are when their
+	// header is not valid
+	inner.Set(ctx, "k", []byte{transformMarker, 0xff, 'x'})
+	if got, err := c.Get(ctx, "k"); err != nil || !bytes.Equal(got.([]byte), []byte{transformMarker, 0xff, 'x'}) {
+		t.Errorf("Get of an untransformed value = %q, %v", got, err)
+	}
+	// without compression or encryption nothing is transformed
+	plain := NewTransformCache(inner, TransformOption{})
+	plain.Set(ctx, "k", []byte{transformMarker, 0})
+	if got, _ := plain.Get(ctx, "k"); !bytes.Equal(got.([]byte), []byte{transformMarker, 0}) {
+		t.Errorf("Get = %q", got)
+	}
+}
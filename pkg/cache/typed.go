@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+var (
+	ErrNotFound = errors.New("cache: key not found")
+
+	codecs = map[string]Codec{
+		"json":    JSONCodec{},
+		"gob":     GobCodec{},
+		"msgpack": MsgpackCodec{},
+	}
+	codecsMu sync.RWMutex
+	typed    *TypedCache
+)
+
+// Codec converts values to and from the bytes stored in the cache.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// RegisterCodec makes a codec available to CacheOption.Codec by name.
+func RegisterCodec(name string, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[name] = codec
+}
+
+// GetCodec returns the codec registered as name, json when name is empty.
+// An unknown name returns a codec failing every call, a typo must not switch
+// the stored format.
+func GetCodec(name string) Codec {
+	if name == "" {
+		name = "json"
+	}
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	if codec, ok := codecs[name]; ok {
+		return codec
+	}
+	return errorCodec{err: fmt.Errorf("cache: unknown codec %q", name)}
+}
+
+type errorCodec struct {
+	err error
+}
+
+func (c errorCodec) Marshal(v interface{}) ([]byte, error) {
+	return nil, c.err
+}
+
+func (c errorCodec) Unmarshal(data []byte, v interface{}) error {
+	return c.err
+}
+
+// TypedCache encodes values with a codec before handing them to a Cache,
+// and reports missing keys as ErrNotFound instead of a nil reply.
+type TypedCache struct {
+	Cache Cache
+	Codec Codec
+}
+
+// new typed cache
+func NewTypedCache(c Cache, codec Codec) *TypedCache {
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+	return &TypedCache{
+		Cache: c,
+		Codec: codec,
+	}
+}
+
+// Get decodes the value of key into v, which must be a pointer.
+func (c *TypedCache) Get(ctx context.Context, key string, v interface{}) error {
+	val, err := c.Cache.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	data, err := replyBytes(val)
+	if err != nil {
+		return err
+	}
+	return c.Codec.Unmarshal(data, v)
+}
+
+func (c *TypedCache) Set(ctx context.Context, key string, v interface{}) error {
+	data, err := c.Codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = c.Cache.Set(ctx, key, data)
+	return err
+}
+
+func (c *TypedCache) SetEx(ctx context.Context, key string, v interface{}, sec int) error {
+	data, err := c.Codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = c.Cache.SetEx(ctx, key, data, sec)
+	return err
+}
+
+// replyBytes turns a Get reply into bytes, mapping a nil reply to ErrNotFound.
+func replyBytes(val interface{}) ([]byte, error) {
+	switch v := val.(type) {
+	case nil:
+		return nil, ErrNotFound
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return nil, fmt.Errorf("cache: unexpected reply type %T", val)
+	}
+}
+
+/*
+typed helpers for caller
+*/
+
+func GetAs(ctx context.Context, key string, v interface{}) error {
+	return typed.Get(ctx, key, v)
+}
+
+func SetAs(ctx context.Context, key string, v interface{}) error {
+	return typed.Set(ctx, key, v)
+}
+
+func SetExAs(ctx context.Context, key string, v interface{}, sec int) error {
+	return typed.SetEx(ctx, key, v, sec)
+}
@@ -0,0 +1,138 @@
+package filter
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+// bits of one shard key, 16MB
+const maxShardBits = 1 << 27
+
+var (
+	bitmapAddScript = redigo.NewScript(1, `
+local added = 0
+for i = 1, #ARGV do
+  if redis.call('SETBIT', KEYS[1], ARGV[i], 1) == 0 then
+    added = 1
+  end
+end
+return added`)
+
+	bitmapExistScript = redigo.NewScript(1, `
+for i = 1, #ARGV do
+  if redis.call('GETBIT', KEYS[1], ARGV[i]) == 0 then
+    return 0
+  end
+end
+return 1`)
+)
+
+// LinkFilterBaseBitmap is a bloom filter on plain redis bitmaps, for servers
+// without the RedisBloom module. A value maps to one shard key, <Key>:<n>
+// when there are several, and its bits are set or tested atomically there.
+// The sizing must not change once values were added.
+type LinkFilterBaseBitmap struct {
+	*LinkFilterBaseRedis
+	shards uint64
+	m      uint64
+	k      uint64
+}
+
+// new filter base bitmap, over the same connections as the redis filter
+func NewBitmapFilter(opt FilterOption) Filter {
+	m, k := bloomSize(opt.Capacity, opt.ErrorRate)
+	shards := uint64(1)
+	if opt.Shards > 1 {
+		shards = uint64(opt.Shards)
+	}
+	if least := (m + maxShardBits - 1) / maxShardBits; shards < least {
+		shards = least
+	}
+	return &LinkFilterBaseBitmap{
+		LinkFilterBaseRedis: newRedisFilter(opt),
+		shards:              shards,
+		m:                   (m + shards - 1) / shards,
+		k:                   k,
+	}
+}
+
+// locate returns the shard key of val and the script arguments.
+func (c *LinkFilterBaseBitmap) locate(val string) (string, redigo.Args) {
+	key := c.Key
+	if c.shards > 1 {
+		h := fnv.New64a()
+		h.Write([]byte(val))
+		key += ":" + strconv.FormatUint(h.Sum64()%c.shards, 10)
+	}
+	args := redigo.Args{key}
+	for _, loc := range bloomLocations(val, c.m, c.k) {
+		args = append(args, loc)
+	}
+	return key, args
+}
+
+func (c *LinkFilterBaseBitmap) Exist(ctx context.Context, val string) (bool, error) {
+	key, args := c.locate(val)
+	r := c.conn(ctx, key)
+	defer r.Close()
+	return redigo.Bool(bitmapExistScript.Do(r, args...))
+}
+
+func (c *LinkFilterBaseBitmap) Add(ctx context.Context, val string) (bool, error) {
+	key, args := c.locate(val)
+	r := c.conn(ctx, key)
+	defer r.Close()
+	return redigo.Bool(bitmapAddScript.Do(r, args...))
+}
+
+func (c *LinkFilterBaseBitmap) MExist(ctx context.Context, vals ...string) ([]bool, error) {
+	return c.evalMany(ctx, bitmapExistScript, vals)
+}
+
+func (c *LinkFilterBaseBitmap) MAdd(ctx context.Context, vals ...string) ([]bool, error) {
+	return c.evalMany(ctx, bitmapAddScript, vals)
+}
+
+// evalMany pipelines script over vals. Cluster shards may live on different
+// nodes, so the scripts are run one by one there.
+func (c *LinkFilterBaseBitmap) evalMany(ctx context.Context, script *redigo.Script, vals []string) ([]bool, error) {
+	if len(vals) == 0 {
+		return nil, nil
+	}
+	res := make([]bool, len(vals))
+	if c.Cluster != nil {
+		for i, val := range vals {
+			key, args := c.locate(val)
+			r := c.conn(ctx, key)
+			ok, err := redigo.Bool(script.Do(r, args...))
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+			res[i] = ok
+		}
+		return res, nil
+	}
+	r := c.get(ctx)
+	defer r.Close()
+	for _, val := range vals {
+		_, args := c.locate(val)
+		if err := script.Send(r, args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.Flush(); err != nil {
+		return nil, err
+	}
+	for i := range vals {
+		ok, err := redigo.Bool(r.Receive())
+		if err != nil {
+			return nil, err
+		}
+		res[i] = ok
+	}
+	return res, nil
+}
@@ -0,0 +1,9 @@
+package filter
+
+import (
+	"github.com/aivencs/kit/pkg/internal/redisconn"
+)
+
+// ErrTimeout marks operations bounded by ctx or CommandTimeout that ran out
+// of time, it is shared with the cache package.
+var ErrTimeout = redisconn.ErrTimeout
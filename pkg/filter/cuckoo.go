@@ -0,0 +1,362 @@
+package filter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+const (
+	cuckooBucketSize = 4
+	cuckooMaxKicks   = 500
+	// share of the slots filled at the requested capacity
+	cuckooLoadFactor = 0.95
+	// RedisBloom default bucket size of CF.RESERVE
+	defaultCuckooBucketSize = 2
+)
+
+var (
+	ErrFilterFull  = errors.New("filter: filter is full")
+	ErrUnsupported = errors.New("filter: operation is not supported by the backend")
+)
+
+// DeletableFilter is a Filter that can forget values, a cuckoo filter.
+type DeletableFilter interface {
+	Filter
+	// Delete removes one occurrence of val and reports whether it was found.
+	// Deleting a value that was never added may remove another one.
+	Delete(ctx context.Context, val string) (bool, error)
+	// Count returns how many times val may have been added.
+	Count(ctx context.Context, val string) (int64, error)
+}
+
+/*
+redis
+*/
+
+// LinkFilterBaseCuckoo is a RedisBloom cuckoo filter. Add only inserts values
+// not in the filter yet, like BF.ADD.
+type LinkFilterBaseCuckoo struct {
+	*LinkFilterBaseRedis
+}
+
+// new filter base cuckoo, reserved with Capacity and Expansion when set
+func NewCuckooFilter(opt FilterOption) DeletableFilter {
+	c := &LinkFilterBaseCuckoo{LinkFilterBaseRedis: newRedisFilter(opt)}
+	if opt.Capacity > 0 {
+		c.prepare(func(ctx context.Context) error {
+			return c.reserve(ctx, opt)
+		})
+	}
+	return c
+}
+
+// reserve creates the filter with the capacity of opt, or checks the bucket
+// count and expansion of an existing one against it.
+func (c *LinkFilterBaseCuckoo) reserve(ctx context.Context, opt FilterOption) error {
+	args := redigo.Args{c.Key, opt.Capacity}
+	if opt.Expansion > 0 {
+		args = args.Add("EXPANSION", opt.Expansion)
+	}
+	r := c.get(ctx)
+	defer r.Close()
+	_, err := r.Do("CF.RESERVE", args...)
+	if err == nil || !strings.Contains(err.Error(), "exists") {
+		return err
+	}
+	info, err := filterInfo(r, "CF.INFO", c.Key)
+	if err != nil {
+		return err
+	}
+	// RedisBloom rounds the expansion up to a power of two
+	if want := nextPow2(int64(opt.Expansion)); opt.Expansion > 0 && info["Expansion rate"] != want {
+		return fmt.Errorf("%w: %s scales by %d, not %d", ErrFilterMismatch, c.Key, info["Expansion rate"], want)
+	}
+	if want := cuckooBuckets(int64(opt.Capacity), info["Bucket size"]); info["Number of buckets"] != want {
+		return fmt.Errorf("%w: %s has %d buckets, not %d", ErrFilterMismatch, c.Key, info["Number of buckets"], want)
+	}
+	return nil
+}
+
+// cuckooBuckets is the bucket count RedisBloom reserves for capacity, the
+// capacity over the bucket size rounded up to a power of two.
+func cuckooBuckets(capacity, bucketSize int64) int64 {
+	if bucketSize <= 0 {
+		bucketSize = defaultCuckooBucketSize
+	}
+	return nextPow2(capacity / bucketSize)
+}
+
+// nextPow2 returns the smallest power of two not below n, at least 1.
+func nextPow2(n int64) int64 {
+	p := int64(1)
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+func (c *LinkFilterBaseCuckoo) Exist(ctx context.Context, val string) (bool, error) {
+	if err := c.ready(ctx); err != nil {
+		return false, err
+	}
+	r := c.get(ctx)
+	defer r.Close()
+	return redigo.Bool(r.Do("CF.EXISTS", c.Key, val))
+}
+
+func (c *LinkFilterBaseCuckoo) Add(ctx context.Context, val string) (bool, error) {
+	if err := c.ready(ctx); err != nil {
+		return false, err
+	}
+	r := c.get(ctx)
+	defer r.Close()
+	return redigo.Bool(r.Do("CF.ADDNX", c.Key, val))
+}
+
+func (c *LinkFilterBaseCuckoo) MExist(ctx context.Context, vals ...string) ([]bool, error) {
+	if err := c.ready(ctx); err != nil {
+		return nil, err
+	}
+	if len(vals) == 0 {
+		return nil, nil
+	}
+	r := c.get(ctx)
+	defer r.Close()
+	return bools(r.Do("CF.MEXISTS", redigo.Args{c.Key}.AddFlat(vals)...))
+}
+
+// MAdd fails with ErrFilterFull when one of the values did not fit.
+func (c *LinkFilterBaseCuckoo) MAdd(ctx context.Context, vals ...string) ([]bool, error) {
+	if err := c.ready(ctx); err != nil {
+		return nil, err
+	}
+	if len(vals) == 0 {
+		return nil, nil
+	}
+	r := c.get(ctx)
+	defer r.Close()
+	ints, err := redigo.Ints(r.Do("CF.INSERTNX", redigo.Args{c.Key, "ITEMS"}.AddFlat(vals)...))
+	if err != nil {
+		return nil, err
+	}
+	res := make([]bool, len(ints))
+	for i, n := range ints {
+		if n < 0 {
+			return nil, ErrFilterFull
+		}
+		res[i] = n == 1
+	}
+	return res, nil
+}
+
+func (c *LinkFilterBaseCuckoo) Delete(ctx context.Context, val string) (bool, error) {
+	if err := c.ready(ctx); err != nil {
+		return false, err
+	}
+	r := c.get(ctx)
+	defer r.Close()
+	return redigo.Bool(r.Do("CF.DEL", c.Key, val))
+}
+
+func (c *LinkFilterBaseCuckoo) Count(ctx context.Context, val string) (int64, error) {
+	if err := c.ready(ctx); err != nil {
+		return 0, err
+	}
+	r := c.get(ctx)
+	defer r.Close()
+	return redigo.Int64(r.Do("CF.COUNT", c.Key, val))
+}
+
+/*
+memory
+*/
+
+// LinkFilterBaseMemoryCuckoo is a cuckoo filter kept in process, with buckets
+// of four 16 bit fingerprints for a false positive rate near 0.01%.
+type LinkFilterBaseMemoryCuckoo struct {
+	mu      sync.Mutex
+	buckets [][cuckooBucketSize]uint16
+	mask    uint64
+	// fingerprint displaced by the last failed insert
+	victim      uint16
+	victimIndex uint64
+	kick        uint64
+}
+
+// new filter base memory cuckoo, sized by Capacity
+func NewMemoryCuckooFilter(opt FilterOption) DeletableFilter {
+	capacity := opt.Capacity
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+	n := uint64(1)
+	for float64(n*cuckooBucketSize)*cuckooLoadFactor < float64(capacity) {
+		n <<= 1
+	}
+	return &LinkFilterBaseMemoryCuckoo{
+		buckets: make([][cuckooBucketSize]uint16, n),
+		mask:    n - 1,
+	}
+}
+
+// locate returns the fingerprint of val and its two buckets.
+func (c *LinkFilterBaseMemoryCuckoo) locate(val string) (uint16, uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(val))
+	sum := mix(h.Sum64())
+	fp := uint16(sum >> 48)
+	if fp == 0 {
+		fp = 1
+	}
+	i1 := sum & c.mask
+	return fp, i1, c.alternate(i1, fp)
+}
+
+// mix spreads every input bit over the whole hash, fnv leaves the last
+// bytes of short values out of the high bits the fingerprint is taken from.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// alternate returns the other bucket of fp, from either of them.
+func (c *LinkFilterBaseMemoryCuckoo) alternate(i uint64, fp uint16) uint64 {
+	return (i ^ (uint64(fp) * 0x5bd1e995)) & c.mask
+}
+
+func (c *LinkFilterBaseMemoryCuckoo) count(fp uint16, i1, i2 uint64) int64 {
+	var n int64
+	for _, slot := range c.buckets[i1] {
+		if slot == fp {
+			n++
+		}
+	}
+	if i2 != i1 {
+		for _, slot := range c.buckets[i2] {
+			if slot == fp {
+				n++
+			}
+		}
+	}
+	if c.victim == fp && (c.victimIndex == i1 || c.victimIndex == i2) {
+		n++
+	}
+	return n
+}
+
+func (c *LinkFilterBaseMemoryCuckoo) put(i uint64, fp uint16) bool {
+	for j, slot := range c.buckets[i] {
+		if slot == 0 {
+			c.buckets[i][j] = fp
+			return true
+		}
+	}
+	return false
+}
+
+// insert relocates fingerprints until fp fits, the last one displaced is
+// kept as the victim when it does not.
+func (c *LinkFilterBaseMemoryCuckoo) insert(fp uint16, i1, i2 uint64) bool {
+	if c.victim != 0 {
+		return false
+	}
+	if c.put(i1, fp) || c.put(i2, fp) {
+		return true
+	}
+	i := i1
+	for n := 0; n < cuckooMaxKicks; n++ {
+		c.kick++
+		j := c.kick % cuckooBucketSize
+		fp, c.buckets[i][j] = c.buckets[i][j], fp
+		i = c.alternate(i, fp)
+		if c.put(i, fp) {
+			return true
+		}
+	}
+	c.victim, c.victimIndex = fp, i
+	return true
+}
+
+func (c *LinkFilterBaseMemoryCuckoo) Exist(ctx context.Context, val string) (bool, error) {
+	fp, i1, i2 := c.locate(val)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.count(fp, i1, i2) > 0, nil
+}
+
+// Add inserts val unless it seems present, and fails with ErrFilterFull
+// when there is no room left.
+func (c *LinkFilterBaseMemoryCuckoo) Add(ctx context.Context, val string) (bool, error) {
+	fp, i1, i2 := c.locate(val)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.count(fp, i1, i2) > 0 {
+		return false, nil
+	}
+	if !c.insert(fp, i1, i2) {
+		return false, ErrFilterFull
+	}
+	return true, nil
+}
+
+func (c *LinkFilterBaseMemoryCuckoo) MExist(ctx context.Context, vals ...string) ([]bool, error) {
+	res := make([]bool, len(vals))
+	for i, val := range vals {
+		res[i], _ = c.Exist(ctx, val)
+	}
+	return res, nil
+}
+
+func (c *LinkFilterBaseMemoryCuckoo) MAdd(ctx context.Context, vals ...string) ([]bool, error) {
+	res := make([]bool, len(vals))
+	for i, val := range vals {
+		ok, err := c.Add(ctx, val)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = ok
+	}
+	return res, nil
+}
+
+func (c *LinkFilterBaseMemoryCuckoo) Delete(ctx context.Context, val string) (bool, error) {
+	fp, i1, i2 := c.locate(val)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.victim == fp && (c.victimIndex == i1 || c.victimIndex == i2) {
+		c.victim = 0
+		return true, nil
+	}
+	for _, i := range []uint64{i1, i2} {
+		for j, slot := range c.buckets[i] {
+			if slot == fp {
+				c.buckets[i][j] = 0
+				// the freed slot makes room for the victim
+				if c.victim != 0 {
+					victim := c.victim
+					c.victim = 0
+					c.insert(victim, c.victimIndex, c.alternate(c.victimIndex, victim))
+				}
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (c *LinkFilterBaseMemoryCuckoo) Count(ctx context.Context, val string) (int64, error) {
+	fp, i1, i2 := c.locate(val)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.count(fp, i1, i2), nil
+}
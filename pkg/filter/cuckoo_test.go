@@ -0,0 +1,159 @@
+package filter
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+func TestMemoryCuckooAddDelete(t *testing.T) {
+	ctx := context.Background()
+	f := NewMemoryCuckooFilter(FilterOption{Capacity: 100})
+	if ok, _ := f.Add(ctx, "a"); !ok {
+		t.Error("first Add = false")
+	}
+	if ok, _ := f.Add(ctx, "a"); ok {
+		t.Error("second Add = true, Add only inserts unseen values")
+	}
+	if n, _ := f.Count(ctx, "a"); n != 1 {
+		t.Errorf("Count = %d, want 1", n)
+	}
+	res, _ := f.MAdd(ctx, "b", "c", "b")
+	if !res[0] || !res[1] || res[2] {
+		t.Errorf("MAdd = %v, want [true true false]", res)
+	}
+	if ok, _ := f.Delete(ctx, "a"); !ok {
+		t.Error("Delete of a present value = false")
+	}
+	if ok, _ := f.Delete(ctx, "a"); ok {
+		t.Error("Delete of a removed value = true")
+	}
+	res, _ = f.MExist(ctx, "a", "b", "c", "d")
+	if res[0] || !res[1] || !res[2] || res[3] {
+		t.Errorf("MExist = %v, want [false true true false]", res)
+	}
+	if n, _ := f.Count(ctx, "a"); n != 0 {
+		t.Errorf("Count = %d, want 0", n)
+	}
+}
+
+func TestMemoryCuckooFalsePositiveRate(t *testing.T) {
+	ctx := context.Background()
+	f := NewMemoryCuckooFilter(FilterOption{Capacity: 10000})
+	for i := 0; i < 10000; i++ {
+		if _, err := f.Add(ctx, "in:"+strconv.Itoa(i)); err != nil {
+			t.Fatalf("Add %d: %v", i, err)
+		}
+	}
+	positives := 0
+	const probes = 100000
+	for i := 0; i < probes; i++ {
+		if ok, _ := f.Exist(ctx, "out:"+strconv.Itoa(i)); ok {
+			positives++
+		}
+	}
+	// 8 slots of 16 bit fingerprints are compared
+	if rate := float64(positives) / probes; rate > 0.001 {
+		t.Errorf("false positive rate = %.5f, want about 0.0001", rate)
+	}
+}
+
+func TestMemoryCuckooSizing(t *testing.T) {
+	tests := []struct {
+		capacity int
+		buckets  int
+	}{
+		{1, 1},
+		{8, 4},
+		{1000, 512},
+		{0, 1 << 19},
+	}
+	for _, tt := range tests {
+		f := NewMemoryCuckooFilter(FilterOption{Capacity: tt.capacity}).(*LinkFilterBaseMemoryCuckoo)
+		if len(f.buckets) != tt.buckets || f.mask != uint64(tt.buckets-1) {
+			t.Errorf("capacity %d: %d buckets, want %d", tt.capacity, len(f.buckets), tt.buckets)
+		}
+	}
+}
+
+func TestMemoryCuckooAlternate(t *testing.T) {
+	f := NewMemoryCuckooFilter(FilterOption{Capacity: 1000}).(*LinkFilterBaseMemoryCuckoo)
+	for i := 0; i < 1000; i++ {
+		fp, i1, i2 := f.locate(strconv.Itoa(i))
+		if fp == 0 {
+			t.Fatal("zero fingerprint, it marks an empty slot")
+		}
+		if f.alternate(i2, fp) != i1 {
+			t.Fatalf("alternate is not symmetric for %d", i)
+		}
+	}
+}
+
+func TestMemoryCuckooFull(t *testing.T) {
+	ctx := context.Background()
+	f := NewMemoryCuckooFilter(FilterOption{Capacity: 8}).(*LinkFilterBaseMemoryCuckoo)
+	var added []string
+	for i := 0; ; i++ {
+		val := strconv.Itoa(i)
+		ok, err := f.Add(ctx, val)
+		if err == ErrFilterFull {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			added = append(added, val)
+		}
+		if i > 1000 {
+			t.Fatal("a filter of 16 slots never filled up")
+		}
+	}
+	if f.victim == 0 {
+		t.Fatal("a full filter has no victim")
+	}
+	// the victim still counts, there are no false negatives
+	for _, val := range added {
+		if ok, _ := f.Exist(ctx, val); !ok {
+			t.Fatalf("%s is missing from the full filter", val)
+		}
+	}
+	if _, err := f.MAdd(ctx, "x", "y"); err != ErrFilterFull {
+		t.Errorf("MAdd error = %v, want ErrFilterFull", err)
+	}
+	// deleting makes room for the victim first
+	if ok, _ := f.Delete(ctx, added[0]); !ok {
+		t.Fatalf("Delete(%s) = false", added[0])
+	}
+	for _, val := range added[1:] {
+		if ok, _ := f.Exist(ctx, val); !ok {
+			t.Fatalf("%s is missing after a delete", val)
+		}
+	}
+	for _, val := range added[1:] {
+		f.Delete(ctx, val)
+	}
+	if f.victim != 0 {
+		t.Error("the victim outlived every value")
+	}
+	if ok, err := f.Add(ctx, "z"); !ok || err != nil {
+		t.Errorf("Add on an emptied filter = %v, %v", ok, err)
+	}
+}
+
+func TestCuckooBuckets(t *testing.T) {
+	tests := []struct {
+		capacity, bucketSize, buckets int64
+	}{
+		{1, 2, 1},
+		{1000, 2, 512},
+		{1024, 2, 512},
+		{1000, 4, 256},
+		{1000, 0, 512},
+	}
+	for _, tt := range tests {
+		if got := cuckooBuckets(tt.capacity, tt.bucketSize); got != tt.buckets {
+			t.Errorf("cuckooBuckets(%d, %d) = %d, want %d", tt.capacity, tt.bucketSize, got, tt.buckets)
+		}
+	}
+}
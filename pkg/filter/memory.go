@@ -0,0 +1,185 @@
+package filter
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"math"
+	"sync"
+)
+
+const (
+	defaultCapacity  = 1000000
+	defaultErrorRate = 0.01
+	bloomMagic       = "kitbf\x01"
+	// bounds of the sizing, a filter read back beyond them is corrupt
+	maxBloomBits   = 1 << 36
+	maxBloomHashes = 64
+	// words read at once, a truncated filter fails before its whole size is
+	// allocated
+	bloomReadWords = 1 << 16
+)
+
+var (
+	ErrCorruptFilter = errors.New("filter: not a filter written by WriteTo")
+)
+
+// LinkFilterBaseMemory is a bloom filter kept in process, sized for the
+// capacity and false positive rate of the option.
+type LinkFilterBaseMemory struct {
+	mu   sync.RWMutex
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+// new filter base memory
+func NewMemoryFilter(opt FilterOption) Filter {
+	m, k := bloomSize(opt.Capacity, opt.ErrorRate)
+	return &LinkFilterBaseMemory{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// bloomSize returns the optimal number of bits and hash functions.
+func bloomSize(capacity int, errorRate float64) (uint64, uint64) {
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+	if errorRate <= 0 || errorRate >= 1 {
+		errorRate = defaultErrorRate
+	}
+	n := float64(capacity)
+	m := math.Ceil(-n * math.Log(errorRate) / (math.Ln2 * math.Ln2))
+	if m > maxBloomBits {
+		m = maxBloomBits
+	}
+	// k is optimal for the bits kept, the error rate grows past the bound
+	k := math.Round(m / n * math.Ln2)
+	if k < 1 {
+		k = 1
+	}
+	if k > maxBloomHashes {
+		k = maxBloomHashes
+	}
+	return uint64(m), uint64(k)
+}
+
+// bloomLocations derives the k bit positions of val by double hashing.
+func bloomLocations(val string, m, k uint64) []uint64 {
+	h := fnv.New128a()
+	h.Write([]byte(val))
+	sum := h.Sum(nil)
+	h1 := binary.BigEndian.Uint64(sum[:8])
+	h2 := binary.BigEndian.Uint64(sum[8:]) | 1
+	locs := make([]uint64, k)
+	for i := range locs {
+		locs[i] = (h1 + uint64(i)*h2) % m
+	}
+	return locs
+}
+
+// Exist reports whether val may have been added, false positives happen at
+// about the configured rate.
+func (c *LinkFilterBaseMemory) Exist(ctx context.Context, val string) (bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	locs := bloomLocations(val, c.m, c.k)
+	for _, loc := range locs {
+		if c.bits[loc/64]&(1<<(loc%64)) == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Add returns true when val was not in the filter yet, the same as BF.ADD.
+func (c *LinkFilterBaseMemory) Add(ctx context.Context, val string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	locs := bloomLocations(val, c.m, c.k)
+	added := false
+	for _, loc := range locs {
+		word, mask := loc/64, uint64(1)<<(loc%64)
+		if c.bits[word]&mask == 0 {
+			c.bits[word] |= mask
+			added = true
+		}
+	}
+	return added, nil
+}
+
+func (c *LinkFilterBaseMemory) MExist(ctx context.Context, vals ...string) ([]bool, error) {
+	res := make([]bool, len(vals))
+	for i, val := range vals {
+		res[i], _ = c.Exist(ctx, val)
+	}
+	return res, nil
+}
+
+func (c *LinkFilterBaseMemory) MAdd(ctx context.Context, vals ...string) ([]bool, error) {
+	res := make([]bool, len(vals))
+	for i, val := range vals {
+		res[i], _ = c.Add(ctx, val)
+	}
+	return res, nil
+}
+
+// WriteTo writes the filter in a form ReadFrom restores.
+func (c *LinkFilterBaseMemory) WriteTo(w io.Writer) (int64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	buf := make([]byte, len(bloomMagic)+16+8*len(c.bits))
+	n := copy(buf, bloomMagic)
+	binary.BigEndian.PutUint64(buf[n:], c.m)
+	binary.BigEndian.PutUint64(buf[n+8:], c.k)
+	n += 16
+	for _, word := range c.bits {
+		binary.BigEndian.PutUint64(buf[n:], word)
+		n += 8
+	}
+	written, err := w.Write(buf)
+	return int64(written), err
+}
+
+// ReadFrom replaces the filter, sizing included, with one written by WriteTo.
+func (c *LinkFilterBaseMemory) ReadFrom(r io.Reader) (int64, error) {
+	header := make([]byte, len(bloomMagic)+16)
+	read, err := io.ReadFull(r, header)
+	if err != nil {
+		return int64(read), ErrCorruptFilter
+	}
+	if string(header[:len(bloomMagic)]) != bloomMagic {
+		return int64(read), ErrCorruptFilter
+	}
+	m := binary.BigEndian.Uint64(header[len(bloomMagic):])
+	k := binary.BigEndian.Uint64(header[len(bloomMagic)+8:])
+	if m == 0 || m > maxBloomBits || k == 0 || k > maxBloomHashes {
+		return int64(read), ErrCorruptFilter
+	}
+	words := int((m + 63) / 64)
+	var bits []uint64
+	data := make([]byte, 8*bloomReadWords)
+	for len(bits) < words {
+		chunk := data
+		if left := words - len(bits); left < bloomReadWords {
+			chunk = data[:8*left]
+		}
+		n, err := io.ReadFull(r, chunk)
+		read += n
+		if err != nil {
+			return int64(read), ErrCorruptFilter
+		}
+		for i := 0; i < len(chunk); i += 8 {
+			bits = append(bits, binary.BigEndian.Uint64(chunk[i:]))
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bits, c.m, c.k = bits, m, k
+	return int64(read), nil
+}
@@ -0,0 +1,123 @@
+package filter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"strconv"
+	"testing"
+)
+
+func TestBloomSize(t *testing.T) {
+	tests := []struct {
+		capacity  int
+		errorRate float64
+		m, k      uint64
+	}{
+		{1000000, 0.01, 9585059, 7},
+		// defaults
+		{0, 0, 9585059, 7},
+		{1000, 0.001, 14378, 10},
+		{1, 0.5, 2, 1},
+		// bounded
+		{1 << 34, 0.01, maxBloomBits, 3},
+		{1 << 40, 0.01, maxBloomBits, 1},
+		{1000, 1e-30, 143776, maxBloomHashes},
+	}
+	for _, tt := range tests {
+		m, k := bloomSize(tt.capacity, tt.errorRate)
+		if m != tt.m || k != tt.k {
+			t.Errorf("bloomSize(%d, %g) = %d, %d, want %d, %d", tt.capacity, tt.errorRate, m, k, tt.m, tt.k)
+		}
+	}
+}
+
+func TestMemoryFilterFalsePositiveRate(t *testing.T) {
+	ctx := context.Background()
+	f := NewMemoryFilter(FilterOption{Capacity: 10000, ErrorRate: 0.01})
+	for i := 0; i < 10000; i++ {
+		f.Add(ctx, "in:"+strconv.Itoa(i))
+	}
+	for i := 0; i < 10000; i++ {
+		if ok, _ := f.Exist(ctx, "in:"+strconv.Itoa(i)); !ok {
+			t.Fatalf("added value %d is missing", i)
+		}
+	}
+	positives := 0
+	const probes = 100000
+	for i := 0; i < probes; i++ {
+		if ok, _ := f.Exist(ctx, "out:"+strconv.Itoa(i)); ok {
+			positives++
+		}
+	}
+	if rate := float64(positives) / probes; rate > 0.02 {
+		t.Errorf("false positive rate = %.4f, want about 0.01", rate)
+	}
+}
+
+func TestMemoryFilterAdd(t *testing.T) {
+	ctx := context.Background()
+	f := NewMemoryFilter(FilterOption{Capacity: 100})
+	res, _ := f.MAdd(ctx, "a", "b", "a")
+	if !res[0] || !res[1] || res[2] {
+		t.Errorf("MAdd = %v, want [true true false]", res)
+	}
+	res, _ = f.MExist(ctx, "a", "c")
+	if !res[0] || res[1] {
+		t.Errorf("MExist = %v, want [true false]", res)
+	}
+}
+
+func TestMemoryFilterRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	f := NewMemoryFilter(FilterOption{Capacity: 1000}).(*LinkFilterBaseMemory)
+	for i := 0; i < 1000; i++ {
+		f.Add(ctx, strconv.Itoa(i))
+	}
+	var buf bytes.Buffer
+	n, err := f.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("WriteTo = %d, %v", n, err)
+	}
+	g := NewMemoryFilter(FilterOption{Capacity: 10}).(*LinkFilterBaseMemory)
+	if n, err := g.ReadFrom(&buf); err != nil || n != int64(len(bloomMagic)+16+8*len(f.bits)) {
+		t.Fatalf("ReadFrom = %d, %v", n, err)
+	}
+	if g.m != f.m || g.k != f.k {
+		t.Errorf("sizing = %d, %d, want %d, %d", g.m, g.k, f.m, f.k)
+	}
+	for i := 0; i < 1000; i++ {
+		if ok, _ := g.Exist(ctx, strconv.Itoa(i)); !ok {
+			t.Fatalf("value %d is missing after ReadFrom", i)
+		}
+	}
+}
+
+func TestMemoryFilterReadCorrupt(t *testing.T) {
+	header := func(m, k uint64) []byte {
+		b := append([]byte(bloomMagic), make([]byte, 16)...)
+		binary.BigEndian.PutUint64(b[len(bloomMagic):], m)
+		binary.BigEndian.PutUint64(b[len(bloomMagic)+8:], k)
+		return b
+	}
+	tests := map[string][]byte{
+		"empty":       nil,
+		"magic":       append([]byte("kitbf\x02"), header(64, 1)[len(bloomMagic):]...),
+		"header":      header(64, 1)[:10],
+		"zero bits":   header(0, 1),
+		"zero hashes": header(64, 0),
+		"huge bits":   header(1<<62, 7),
+		"many hashes": header(64, maxBloomHashes+1),
+		"truncated":   append(header(128, 1), make([]byte, 8)...),
+	}
+	for name, data := range tests {
+		f := NewMemoryFilter(FilterOption{Capacity: 10}).(*LinkFilterBaseMemory)
+		m := f.m
+		if _, err := f.ReadFrom(bytes.NewReader(data)); err != ErrCorruptFilter {
+			t.Errorf("%s: ReadFrom error = %v, want ErrCorruptFilter", name, err)
+		}
+		if f.m != m {
+			t.Errorf("%s: filter was replaced", name)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package filter
+
+import (
+	"context"
+	"time"
+
+	"github.com/aivencs/kit/pkg/internal/redisconn"
+	"github.com/aivencs/kit/pkg/metrics"
+)
+
+const metricsComponent = "filter"
+
+// MeteredFilter reports the operations of a Filter to a collector, Exist
+// counts a present value as a hit.
+type MeteredFilter struct {
+	Filter
+	collector metrics.Collector
+}
+
+// MeteredDeletableFilter is a MeteredFilter over a DeletableFilter, Delete
+// and Count are reported too.
+type MeteredDeletableFilter struct {
+	*MeteredFilter
+	deletable DeletableFilter
+}
+
+// new metered filter, the pool of a redis backed filter is registered too. A
+// DeletableFilter stays one once wrapped.
+func NewMeteredFilter(f Filter, collector metrics.Collector) Filter {
+	if rf, ok := f.(interface{ PoolStats() metrics.PoolStats }); ok {
+		collector.RegisterPool(metricsComponent, rf.PoolStats)
+	}
+	c := &MeteredFilter{Filter: f, collector: collector}
+	if df, ok := f.(DeletableFilter); ok {
+		return &MeteredDeletableFilter{MeteredFilter: c, deletable: df}
+	}
+	return c
+}
+
+// PoolStats sums the stats of every node pool on a cluster.
+func (c *LinkFilterBaseRedis) PoolStats() metrics.PoolStats {
+	return redisconn.PoolStats(c.Pool, c.Cluster)
+}
+
+func (c *MeteredFilter) Exist(ctx context.Context, val string) (bool, error) {
+	start := time.Now()
+	ok, err := c.Filter.Exist(ctx, val)
+	c.collector.ObserveOp(metricsComponent, "exist", time.Since(start), err)
+	if err == nil {
+		if ok {
+			c.collector.ObserveLookup(metricsComponent, "exist", 1, 0)
+		} else {
+			c.collector.ObserveLookup(metricsComponent, "exist", 0, 1)
+		}
+	}
+	return ok, err
+}
+
+func (c *MeteredFilter) Add(ctx context.Context, val string) (bool, error) {
+	start := time.Now()
+	ok, err := c.Filter.Add(ctx, val)
+	c.collector.ObserveOp(metricsComponent, "add", time.Since(start), err)
+	return ok, err
+}
+
+func (c *MeteredFilter) MExist(ctx context.Context, vals ...string) ([]bool, error) {
+	start := time.Now()
+	res, err := c.Filter.MExist(ctx, vals...)
+	c.collector.ObserveOp(metricsComponent, "mexist", time.Since(start), err)
+	if err == nil {
+		hits := 0
+		for _, ok := range res {
+			if ok {
+				hits++
+			}
+		}
+		c.collector.ObserveLookup(metricsComponent, "mexist", hits, len(res)-hits)
+	}
+	return res, err
+}
+
+func (c *MeteredFilter) MAdd(ctx context.Context, vals ...string) ([]bool, error) {
+	start := time.Now()
+	res, err := c.Filter.MAdd(ctx, vals...)
+	c.collector.ObserveOp(metricsComponent, "madd", time.Since(start), err)
+	return res, err
+}
+
+func (c *MeteredDeletableFilter) Delete(ctx context.Context, val string) (bool, error) {
+	start := time.Now()
+	ok, err := c.deletable.Delete(ctx, val)
+	c.collector.ObserveOp(metricsComponent, "delete", time.Since(start), err)
+	return ok, err
+}
+
+func (c *MeteredDeletableFilter) Count(ctx context.Context, val string) (int64, error) {
+	start := time.Now()
+	n, err := c.deletable.Count(ctx, val)
+	c.collector.ObserveOp(metricsComponent, "count", time.Since(start), err)
+	return n, err
+}
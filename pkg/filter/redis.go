@@ -2,11 +2,16 @@ package filter
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	redisbloom "github.com/RedisBloom/redisbloom-go"
+	"github.com/aivencs/kit/pkg/internal/redisconn"
+	"github.com/aivencs/kit/pkg/metrics"
 	redigo "github.com/gomodule/redigo/redis"
+	"github.com/mna/redisc"
 )
 
 var (
@@ -20,12 +25,23 @@ var (
 type Filter interface {
 	Exist(ctx context.Context, val string) (bool, error)
 	Add(ctx context.Context, val string) (bool, error)
+	// batch forms in one round trip, with one result per value
+	MExist(ctx context.Context, vals ...string) ([]bool, error)
+	MAdd(ctx context.Context, vals ...string) ([]bool, error)
 }
 
 type LinkFilterBaseRedis struct {
-	Client *redisbloom.Client
-	Pool   *redigo.Pool
-	Key    string
+	Client  *redisbloom.Client
+	Pool    *redigo.Pool
+	Cluster *redisc.Cluster
+	Key     string
+	timeout time.Duration
+	// pending reservation, retried by the next operation after a transient
+	// error, and a mismatch returned by every operation
+	reserved uint32
+	mu       sync.Mutex
+	pending  func(ctx context.Context) error
+	err      error
 }
 
 type FilterOption struct {
@@ -40,6 +56,38 @@ type FilterOption struct {
 	MaxIdle     int
 	IdleTimeout time.Duration
 	MaxActive   int
+	// default bound of an operation whose ctx has no deadline, zero waits
+	// forever
+	CommandTimeout time.Duration
+	DialTimeout    time.Duration
+	// redis://[user:password@]host:port/db, or rediss:// over tls, replaces
+	// Host and takes precedence over the credentials and DB
+	URL string
+	// tls, the files are pem encoded
+	TLS           bool
+	TLSCAFile     string
+	TLSCertFile   string
+	TLSKeyFile    string
+	TLSServerName string
+	TLSSkipVerify bool
+	// sentinel
+	MasterName    string
+	SentinelAddrs []string
+	// cluster
+	ClusterNodes []string
+	// operations and pool stats are reported when set
+	Collector metrics.Collector
+	// expected number of values and false positive rate, defaults to 1e6 and
+	// 0.01. A redis filter is reserved with them when Capacity is set, cuckoo
+	// filters ignore the rate.
+	Capacity  int
+	ErrorRate float64
+	// redis only, growth of a full filter, or none at all for bloom
+	Expansion  int
+	NonScaling bool
+	// bitmap only, keys the bits are spread over, raised when a key would
+	// exceed 16MB
+	Shards int
 }
 
 func applyOption(opt FilterOption) {
@@ -59,59 +107,179 @@ func InitFilter(name string, opt FilterOption) {
 		switch name {
 		case "redis":
 			filter = NewRedisFilter(opt)
+		case "memory":
+			filter = NewMemoryFilter(opt)
+		case "bitmap":
+			filter = NewBitmapFilter(opt)
+		case "cuckoo":
+			filter = NewCuckooFilter(opt)
+		case "memory-cuckoo":
+			filter = NewMemoryCuckooFilter(opt)
 		default:
 			filter = NewRedisFilter(opt)
 		}
+		if opt.Collector != nil {
+			filter = NewMeteredFilter(filter, opt.Collector)
+		}
 	})
 }
 
-// new filter base redis
+// new filter base redis, a cluster when ClusterNodes is set and a sentinel
+// monitored master when MasterName is set. With a Capacity the filter is
+// reserved, and operations fail for good when it exists with other
+// parameters.
 func NewRedisFilter(opt FilterOption) Filter {
-	applyOption(opt)
-	rdp := &redigo.Pool{
-		MaxIdle:     maxIdle,
-		IdleTimeout: idleTimeout,
-		MaxActive:   maxActive,
-		Dial: func() (redigo.Conn, error) {
-			c, err := redigo.Dial("tcp", opt.Host)
-			if err != nil {
-				return nil, err
-			}
-			if opt.Auth {
-				if _, err := c.Do("AUTH", opt.Password); err != nil {
-					c.Close()
-					return nil, err
-				}
-				if _, err := c.Do("SELECT", opt.DB); err != nil {
-					c.Close()
-					return nil, err
-				}
-			}
-			return c, err
-		},
-		TestOnBorrow: func(c redigo.Conn, t time.Time) error {
-			_, err := c.Do("PING")
+	c := newRedisFilter(opt)
+	if opt.Capacity > 0 {
+		c.prepare(func(ctx context.Context) error {
+			return c.reserve(ctx, opt)
+		})
+	}
+	return c
+}
+
+// prepare runs fn before the first operation, it is tried at once and again
+// by every operation until it succeeds. ErrFilterMismatch is final.
+func (c *LinkFilterBaseRedis) prepare(fn func(ctx context.Context) error) {
+	c.pending = fn
+	c.ready(context.Background())
+}
+
+func (c *LinkFilterBaseRedis) ready(ctx context.Context) error {
+	if atomic.LoadUint32(&c.reserved) == 1 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	if c.pending != nil {
+		err := c.pending(ctx)
+		if errors.Is(err, ErrFilterMismatch) {
+			c.err = err
+		}
+		if err != nil {
 			return err
-		},
+		}
 	}
+	atomic.StoreUint32(&c.reserved, 1)
+	return nil
+}
+
+func newRedisFilter(opt FilterOption) *LinkFilterBaseRedis {
+	applyOption(opt)
+	if len(opt.ClusterNodes) > 0 {
+		return &LinkFilterBaseRedis{
+			Cluster: redisconn.NewCluster(connOption(opt)),
+			Key:     opt.Key,
+			timeout: opt.CommandTimeout,
+		}
+	}
+	rdp := redisconn.NewPool(connOption(opt))
 	rbc := redisbloom.NewClientFromPool(rdp, opt.Key)
 	return &LinkFilterBaseRedis{
-		Pool:   rdp,
-		Client: rbc,
-		Key:    opt.Key,
+		Pool:    rdp,
+		Client:  rbc,
+		Key:     opt.Key,
+		timeout: opt.CommandTimeout,
 	}
 }
 
+func connOption(opt FilterOption) redisconn.Option {
+	return redisconn.Option{
+		Name:           "filter",
+		Host:           opt.Host,
+		Auth:           opt.Auth,
+		Username:       opt.Username,
+		Password:       opt.Password,
+		DB:             opt.DB,
+		URL:            opt.URL,
+		CommandTimeout: opt.CommandTimeout,
+		DialTimeout:    opt.DialTimeout,
+		TLS:            opt.TLS,
+		TLSCAFile:      opt.TLSCAFile,
+		TLSCertFile:    opt.TLSCertFile,
+		TLSKeyFile:     opt.TLSKeyFile,
+		TLSServerName:  opt.TLSServerName,
+		TLSSkipVerify:  opt.TLSSkipVerify,
+		MasterName:     opt.MasterName,
+		SentinelAddrs:  opt.SentinelAddrs,
+		ClusterNodes:   opt.ClusterNodes,
+		MaxIdle:        maxIdle,
+		IdleTimeout:    idleTimeout,
+		MaxActive:      maxActive,
+	}
+}
+
+// get borrows a connection whose commands run under ctx, bounded by the
+// default command timeout when ctx has no deadline. Cluster connections are
+// bound to the slot of the filter key and follow MOVED and ASK redirects.
+func (c *LinkFilterBaseRedis) get(ctx context.Context) redigo.Conn {
+	return c.conn(ctx, c.Key)
+}
+
+// conn is get bound to the slot of key.
+func (c *LinkFilterBaseRedis) conn(ctx context.Context, key string) redigo.Conn {
+	return redisconn.Get(ctx, c.Pool, c.Cluster, c.timeout, key)
+}
+
 /*
 function of redis filter
 */
 
 func (c *LinkFilterBaseRedis) Exist(ctx context.Context, val string) (bool, error) {
-	return c.Client.Exists(c.Key, val)
+	if err := c.ready(ctx); err != nil {
+		return false, err
+	}
+	r := c.get(ctx)
+	defer r.Close()
+	return redigo.Bool(r.Do("BF.EXISTS", c.Key, val))
 }
 
 func (c *LinkFilterBaseRedis) Add(ctx context.Context, val string) (bool, error) {
-	return c.Client.Add(c.Key, val)
+	if err := c.ready(ctx); err != nil {
+		return false, err
+	}
+	r := c.get(ctx)
+	defer r.Close()
+	return redigo.Bool(r.Do("BF.ADD", c.Key, val))
+}
+
+func (c *LinkFilterBaseRedis) MExist(ctx context.Context, vals ...string) ([]bool, error) {
+	if err := c.ready(ctx); err != nil {
+		return nil, err
+	}
+	if len(vals) == 0 {
+		return nil, nil
+	}
+	r := c.get(ctx)
+	defer r.Close()
+	return bools(r.Do("BF.MEXISTS", redigo.Args{c.Key}.AddFlat(vals)...))
+}
+
+func (c *LinkFilterBaseRedis) MAdd(ctx context.Context, vals ...string) ([]bool, error) {
+	if err := c.ready(ctx); err != nil {
+		return nil, err
+	}
+	if len(vals) == 0 {
+		return nil, nil
+	}
+	r := c.get(ctx)
+	defer r.Close()
+	return bools(r.Do("BF.MADD", redigo.Args{c.Key}.AddFlat(vals)...))
+}
+
+func bools(reply interface{}, err error) ([]bool, error) {
+	ints, err := redigo.Ints(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]bool, len(ints))
+	for i, n := range ints {
+		res[i] = n == 1
+	}
+	return res, nil
 }
 
 /*
@@ -125,3 +293,52 @@ func Exist(ctx context.Context, val string) (bool, error) {
 func Add(ctx context.Context, val string) (bool, error) {
 	return filter.Add(ctx, val)
 }
+
+func MExist(ctx context.Context, vals ...string) ([]bool, error) {
+	return filter.MExist(ctx, vals...)
+}
+
+func MAdd(ctx context.Context, vals ...string) ([]bool, error) {
+	return filter.MAdd(ctx, vals...)
+}
+
+// Delete and Count need a DeletableFilter, a cuckoo filter.
+func Delete(ctx context.Context, val string) (bool, error) {
+	df, ok := filter.(DeletableFilter)
+	if !ok {
+		return false, ErrUnsupported
+	}
+	return df.Delete(ctx, val)
+}
+
+func Count(ctx context.Context, val string) (int64, error) {
+	df, ok := filter.(DeletableFilter)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+	return df.Count(ctx, val)
+}
+
+// Unseen returns the vals the filter has not seen, each once and in order.
+// Nothing is added.
+func Unseen(ctx context.Context, vals ...string) ([]string, error) {
+	var unique []string
+	dup := make(map[string]struct{}, len(vals))
+	for _, val := range vals {
+		if _, ok := dup[val]; !ok {
+			dup[val] = struct{}{}
+			unique = append(unique, val)
+		}
+	}
+	seen, err := filter.MExist(ctx, unique...)
+	if err != nil {
+		return nil, err
+	}
+	var unseen []string
+	for i, val := range unique {
+		if !seen[i] {
+			unseen = append(unseen, val)
+		}
+	}
+	return unseen, nil
+}
@@ -0,0 +1,95 @@
+package filter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+// RedisBloom default growth of a scaling filter
+const defaultExpansion = 2
+
+var (
+	ErrFilterMismatch = errors.New("filter: existing filter was reserved with other parameters")
+)
+
+// reserve creates the filter with the capacity and error rate of opt, or
+// checks an existing one against them. BF.INFO does not report the error
+// rate, so only the capacity and expansion of an existing filter are checked.
+func (c *LinkFilterBaseRedis) reserve(ctx context.Context, opt FilterOption) error {
+	errorRate := opt.ErrorRate
+	if errorRate <= 0 || errorRate >= 1 {
+		errorRate = defaultErrorRate
+	}
+	args := redigo.Args{c.Key, errorRate, opt.Capacity}
+	if opt.Expansion > 0 && !opt.NonScaling {
+		args = args.Add("EXPANSION", opt.Expansion)
+	}
+	if opt.NonScaling {
+		args = args.Add("NONSCALING")
+	}
+	r := c.get(ctx)
+	defer r.Close()
+	_, err := r.Do("BF.RESERVE", args...)
+	if err == nil || !strings.Contains(err.Error(), "exists") {
+		return err
+	}
+	info, err := filterInfo(r, "BF.INFO", c.Key)
+	if err != nil {
+		return err
+	}
+	// a non-scaling filter reports no expansion rate
+	expansion, scaling := info["Expansion rate"]
+	switch {
+	case opt.NonScaling && scaling:
+		return fmt.Errorf("%w: %s scales by %d, not NONSCALING", ErrFilterMismatch, c.Key, expansion)
+	case !opt.NonScaling && !scaling:
+		return fmt.Errorf("%w: %s is NONSCALING", ErrFilterMismatch, c.Key)
+	case opt.Expansion > 0 && scaling && expansion != int64(opt.Expansion):
+		return fmt.Errorf("%w: %s scales by %d, not %d", ErrFilterMismatch, c.Key, expansion, opt.Expansion)
+	}
+	if want := scaledCapacity(int64(opt.Capacity), expansion, info["Number of filters"]); info["Capacity"] != want {
+		return fmt.Errorf("%w: %s has a capacity of %d, not %d", ErrFilterMismatch, c.Key, info["Capacity"], want)
+	}
+	return nil
+}
+
+// scaledCapacity is the total capacity of a filter reserved with capacity
+// after growing to n sub filters.
+func scaledCapacity(capacity, expansion, n int64) int64 {
+	if expansion <= 0 {
+		expansion = defaultExpansion
+	}
+	total, layer := int64(0), capacity
+	for i := int64(0); i < n; i++ {
+		total += layer
+		layer *= expansion
+	}
+	return total
+}
+
+// filterInfo returns the fields of BF.INFO or CF.INFO, nil values are left
+// out.
+func filterInfo(r redigo.Conn, cmd, key string) (map[string]int64, error) {
+	values, err := redigo.Values(r.Do(cmd, key))
+	if err != nil {
+		return nil, err
+	}
+	info := make(map[string]int64, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		name, err := redigo.String(values[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		if values[i+1] == nil {
+			continue
+		}
+		if info[name], err = redigo.Int64(values[i+1], nil); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
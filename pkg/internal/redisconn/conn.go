@@ -0,0 +1,159 @@
+package redisconn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/mna/redisc"
+)
+
+const (
+	clusterAttempts   = 5
+	clusterRetryDelay = 100 * time.Millisecond
+)
+
+var ErrTimeout = errors.New("redis: operation timed out")
+
+type timeoutError struct {
+	err error
+}
+
+func (e *timeoutError) Error() string {
+	return ErrTimeout.Error() + ": " + e.err.Error()
+}
+
+func (e *timeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+func (e *timeoutError) Unwrap() error {
+	return e.err
+}
+
+// WrapError marks deadline and network timeouts with ErrTimeout.
+func WrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+		return &timeoutError{err: err}
+	}
+	return err
+}
+
+// Get borrows a connection whose commands run under ctx, bounded by timeout
+// when ctx has no deadline. Cluster connections are bound to the slot of keys
+// and follow MOVED and ASK redirects.
+func Get(ctx context.Context, pool *redigo.Pool, cluster *redisc.Cluster, timeout time.Duration, keys ...string) redigo.Conn {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cancel := context.CancelFunc(func() {})
+	if _, ok := ctx.Deadline(); !ok && timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	if cluster == nil {
+		conn, err := pool.GetContext(ctx)
+		if err != nil {
+			cancel()
+			return errorConn{err: WrapError(err)}
+		}
+		return WithContext(conn, ctx, cancel)
+	}
+	conn := cluster.Get()
+	if len(keys) > 0 {
+		redisc.BindConn(conn, keys...)
+	}
+	if rc, err := redisc.RetryConn(conn, clusterAttempts, clusterRetryDelay); err == nil {
+		conn = rc
+	}
+	return WithContext(conn, ctx, cancel)
+}
+
+// WithContext runs every command of conn under ctx, cancel is called when
+// the connection is closed.
+func WithContext(conn redigo.Conn, ctx context.Context, cancel context.CancelFunc) redigo.Conn {
+	return &ctxConn{Conn: conn, ctx: ctx, cancel: cancel}
+}
+
+type ctxConn struct {
+	redigo.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+	// reply of a command the caller stopped waiting for
+	abandoned chan struct{}
+}
+
+func (c *ctxConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, WrapError(err)
+	}
+	if cc, ok := c.Conn.(redigo.ConnWithContext); ok {
+		reply, err := cc.DoContext(c.ctx, cmd, args...)
+		return reply, WrapError(err)
+	}
+	if c.ctx.Done() == nil {
+		reply, err := c.Conn.Do(cmd, args...)
+		return reply, WrapError(err)
+	}
+	// cluster connections ignore ctx, from the pool wait to the reply, so
+	// the command runs aside and is left behind when ctx ends first
+	var reply interface{}
+	var err error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reply, err = c.Conn.Do(cmd, args...)
+	}()
+	select {
+	case <-done:
+		return reply, WrapError(err)
+	case <-c.ctx.Done():
+		c.abandoned = done
+		return nil, WrapError(c.ctx.Err())
+	}
+}
+
+func (c *ctxConn) Receive() (interface{}, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, WrapError(err)
+	}
+	if cc, ok := c.Conn.(redigo.ConnWithContext); ok {
+		reply, err := cc.ReceiveContext(c.ctx)
+		return reply, WrapError(err)
+	}
+	reply, err := c.Conn.Receive()
+	return reply, WrapError(err)
+}
+
+// Close returns at once, a connection still running an abandoned command
+// is closed once it ends.
+func (c *ctxConn) Close() error {
+	if c.abandoned != nil {
+		go func() {
+			<-c.abandoned
+			c.Conn.Close()
+			c.cancel()
+		}()
+		return nil
+	}
+	err := c.Conn.Close()
+	c.cancel()
+	return err
+}
+
+// errorConn is returned when no connection could be borrowed.
+type errorConn struct {
+	err error
+}
+
+func (c errorConn) Close() error                                   { return nil }
+func (c errorConn) Err() error                                     { return c.err }
+func (c errorConn) Do(string, ...interface{}) (interface{}, error) { return nil, c.err }
+func (c errorConn) Send(string, ...interface{}) error              { return c.err }
+func (c errorConn) Flush() error                                   { return c.err }
+func (c errorConn) Receive() (interface{}, error)                  { return nil, c.err }
@@ -0,0 +1,224 @@
+// Package redisconn holds the redis connection code shared by the cache and
+// filter packages.
+package redisconn
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FZambia/sentinel"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/mna/redisc"
+)
+
+const sentinelTimeout = 500 * time.Millisecond
+
+// Option is the connection part of the cache and filter options.
+type Option struct {
+	// prefix of the errors, the package dialing
+	Name     string
+	Host     string
+	Auth     bool
+	Username string
+	Password string
+	DB       int
+	URL      string
+	// bound of the reads and writes of pooled connections
+	CommandTimeout time.Duration
+	DialTimeout    time.Duration
+	// tls, the files are pem encoded
+	TLS           bool
+	TLSCAFile     string
+	TLSCertFile   string
+	TLSKeyFile    string
+	TLSServerName string
+	TLSSkipVerify bool
+	// sentinel
+	MasterName    string
+	SentinelAddrs []string
+	// cluster
+	ClusterNodes []string
+	// pool
+	MaxIdle     int
+	IdleTimeout time.Duration
+	MaxActive   int
+	Wait        bool
+}
+
+// NewPool returns a pool dialing the URL, the sentinel monitored master or
+// the host of opt, in that order.
+func NewPool(opt Option) *redigo.Pool {
+	pool := &redigo.Pool{
+		MaxIdle:     opt.MaxIdle,
+		IdleTimeout: opt.IdleTimeout,
+		MaxActive:   opt.MaxActive,
+		Wait:        opt.Wait,
+		DialContext: func(ctx context.Context) (redigo.Conn, error) {
+			if opt.URL != "" {
+				return dialURL(ctx, opt)
+			}
+			return dial(ctx, opt.Host, opt)
+		},
+		TestOnBorrow: func(c redigo.Conn, t time.Time) error {
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+	if opt.MasterName != "" {
+		stl := newSentinel(opt)
+		pool.DialContext = func(ctx context.Context) (redigo.Conn, error) {
+			addr, err := stl.MasterAddr()
+			if err != nil {
+				return nil, err
+			}
+			return dial(ctx, addr, opt)
+		}
+		pool.TestOnBorrow = func(c redigo.Conn, t time.Time) error {
+			if !sentinel.TestRole(c, "master") {
+				return errors.New(opt.Name + ": connection is not bound to the master")
+			}
+			return nil
+		}
+	}
+	return pool
+}
+
+// DB returns the database selected on dial, a non-zero URL path wins.
+func DB(opt Option) int {
+	if u, err := url.Parse(opt.URL); err == nil && opt.URL != "" {
+		if db, err := strconv.Atoi(strings.TrimPrefix(u.Path, "/")); err == nil && db != 0 {
+			return db
+		}
+	}
+	return opt.DB
+}
+
+// dial connects to addr, authenticates and selects the configured db.
+func dial(ctx context.Context, addr string, opt Option) (redigo.Conn, error) {
+	options, err := dialOptions(opt)
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, redigo.DialDatabase(opt.DB))
+	return redigo.DialContext(ctx, "tcp", addr, options...)
+}
+
+func dialURL(ctx context.Context, opt Option) (redigo.Conn, error) {
+	options, err := dialOptions(opt)
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, redigo.DialDatabase(opt.DB))
+	return redigo.DialURLContext(ctx, opt.URL, options...)
+}
+
+// dialOptions holds everything but the db, which a cluster cannot select.
+func dialOptions(opt Option) ([]redigo.DialOption, error) {
+	var options []redigo.DialOption
+	if opt.DialTimeout > 0 {
+		options = append(options, redigo.DialConnectTimeout(opt.DialTimeout))
+	}
+	if opt.CommandTimeout > 0 {
+		options = append(options, redigo.DialWriteTimeout(opt.CommandTimeout))
+	}
+	if opt.Auth {
+		// redis 6 acl when a username is given
+		if opt.Username != "" {
+			options = append(options, redigo.DialUsername(opt.Username))
+		}
+		options = append(options, redigo.DialPassword(opt.Password))
+	}
+	// DialURL picks tls from the scheme after every option, a redis:// URL
+	// would connect in plaintext
+	if opt.TLS && strings.HasPrefix(opt.URL, "redis://") {
+		return nil, errors.New(opt.Name + ": TLS needs a rediss:// URL")
+	}
+	if opt.TLS || strings.HasPrefix(opt.URL, "rediss://") {
+		config, err := tlsConfig(opt)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, redigo.DialUseTLS(true), redigo.DialTLSConfig(config))
+	}
+	return options, nil
+}
+
+func tlsConfig(opt Option) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         opt.TLSServerName,
+		InsecureSkipVerify: opt.TLSSkipVerify,
+	}
+	if opt.TLSCAFile != "" {
+		pem, err := os.ReadFile(opt.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New(opt.Name + ": no certificate found in " + opt.TLSCAFile)
+		}
+	}
+	if opt.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opt.TLSCertFile, opt.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func newSentinel(opt Option) *sentinel.Sentinel {
+	return &sentinel.Sentinel{
+		Addrs:      opt.SentinelAddrs,
+		MasterName: opt.MasterName,
+		Dial: func(addr string) (redigo.Conn, error) {
+			return redigo.DialTimeout("tcp", addr, sentinelTimeout, sentinelTimeout, sentinelTimeout)
+		},
+	}
+}
+
+// NewCluster returns a cluster whose DialOptions carry no read timeout, so
+// connections dialed with them may block in pubsub. Pooled connections
+// cannot follow ctx, Get leaves their commands behind when ctx ends and
+// CommandTimeout bounds how long they hold the connection.
+func NewCluster(opt Option) *redisc.Cluster {
+	options, err := dialOptions(opt)
+	cluster := &redisc.Cluster{
+		StartupNodes: opt.ClusterNodes,
+		DialOptions:  options,
+		PoolWaitTime: opt.CommandTimeout,
+		CreatePool: func(addr string, options ...redigo.DialOption) (*redigo.Pool, error) {
+			if err != nil {
+				return nil, err
+			}
+			if opt.CommandTimeout > 0 {
+				options = append(options[:len(options):len(options)], redigo.DialReadTimeout(opt.CommandTimeout))
+			}
+			return &redigo.Pool{
+				MaxIdle:     opt.MaxIdle,
+				IdleTimeout: opt.IdleTimeout,
+				MaxActive:   opt.MaxActive,
+				Wait:        opt.Wait,
+				Dial: func() (redigo.Conn, error) {
+					return redigo.Dial("tcp", addr, options...)
+				},
+				TestOnBorrow: func(c redigo.Conn, t time.Time) error {
+					_, err := c.Do("PING")
+					return err
+				},
+			}, nil
+		},
+	}
+	// the slot layout is refreshed lazily on the first MOVED reply if the
+	// seed nodes cannot be reached yet
+	cluster.Refresh()
+	return cluster
+}
@@ -0,0 +1,33 @@
+package redisconn
+
+import (
+	"github.com/aivencs/kit/pkg/metrics"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/mna/redisc"
+)
+
+// PoolStats returns the stats of pool, or sums those of every node pool when
+// cluster is set.
+func PoolStats(pool *redigo.Pool, cluster *redisc.Cluster) metrics.PoolStats {
+	if cluster == nil {
+		return poolStats(pool.Stats())
+	}
+	var total metrics.PoolStats
+	for _, s := range cluster.Stats() {
+		stats := poolStats(s)
+		total.Active += stats.Active
+		total.Idle += stats.Idle
+		total.WaitCount += stats.WaitCount
+		total.WaitDuration += stats.WaitDuration
+	}
+	return total
+}
+
+func poolStats(s redigo.PoolStats) metrics.PoolStats {
+	return metrics.PoolStats{
+		Active:       s.ActiveCount,
+		Idle:         s.IdleCount,
+		WaitCount:    s.WaitCount,
+		WaitDuration: s.WaitDuration,
+	}
+}
@@ -0,0 +1,131 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// interval of the sweeps dropping the state of idle keys
+const sweepInterval = time.Minute
+
+// MemoryLimiter implements the same algorithms as RedisLimiter in process,
+// for tests and single instance deployments.
+type MemoryLimiter struct {
+	mu      sync.Mutex
+	opt     LimiterOption
+	buckets map[string]*bucket
+	windows map[string][]time.Time
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens float64
+	ts     time.Time
+}
+
+// new limiter base memory
+func NewMemoryLimiter(opt LimiterOption) Limiter {
+	return &MemoryLimiter{
+		opt:     applyOption(opt),
+		buckets: make(map[string]*bucket),
+		windows: make(map[string][]time.Time),
+	}
+}
+
+func (c *MemoryLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	return c.AllowN(ctx, key, 1)
+}
+
+func (c *MemoryLimiter) AllowN(ctx context.Context, key string, n int) (bool, error) {
+	ok, _ := c.reserve(key, n, time.Now())
+	return ok, nil
+}
+
+func (c *MemoryLimiter) Wait(ctx context.Context, key string) error {
+	return wait(ctx, func() (bool, time.Duration, error) {
+		ok, delay := c.reserve(key, 1, time.Now())
+		return ok, delay, nil
+	})
+}
+
+func (c *MemoryLimiter) reserve(key string, n int, now time.Time) (bool, time.Duration) {
+	limit := c.opt.limitOf(key)
+	if limit.Rate <= 0 {
+		return false, -1
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.swept) >= sweepInterval {
+		c.sweep(now)
+	}
+	if c.opt.Algorithm == SlidingWindow {
+		return c.slide(key, limit, n, now)
+	}
+	return c.take(key, limit, n, now)
+}
+
+func (c *MemoryLimiter) take(key string, limit Limit, n int, now time.Time) (bool, time.Duration) {
+	burst := float64(limit.Burst)
+	if float64(n) > burst {
+		return false, -1
+	}
+	rate := float64(limit.Rate) / float64(limit.Period)
+	b, ok := c.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, ts: now}
+		c.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.ts); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+float64(elapsed)*rate)
+	}
+	b.ts = now
+	if b.tokens >= float64(n) {
+		b.tokens -= float64(n)
+		return true, 0
+	}
+	return false, time.Duration(math.Ceil((float64(n) - b.tokens) / rate))
+}
+
+func (c *MemoryLimiter) slide(key string, limit Limit, n int, now time.Time) (bool, time.Duration) {
+	if n > limit.Rate {
+		return false, -1
+	}
+	events := c.windows[key]
+	start := now.Add(-limit.Period)
+	i := 0
+	for i < len(events) && !events[i].After(start) {
+		i++
+	}
+	events = events[i:]
+	if len(events)+n <= limit.Rate {
+		for j := 0; j < n; j++ {
+			events = append(events, now)
+		}
+		c.windows[key] = events
+		return true, 0
+	}
+	c.windows[key] = events
+	oldest := events[len(events)+n-limit.Rate-1]
+	return false, oldest.Add(limit.Period).Sub(now)
+}
+
+// sweep drops full buckets and windows without events, both behave as if
+// the key was never seen.
+func (c *MemoryLimiter) sweep(now time.Time) {
+	c.swept = now
+	for key, b := range c.buckets {
+		limit := c.opt.limitOf(key)
+		refill := time.Duration(float64(limit.Burst) / float64(limit.Rate) * float64(limit.Period))
+		if now.Sub(b.ts) >= refill {
+			delete(c.buckets, key)
+		}
+	}
+	for key, events := range c.windows {
+		limit := c.opt.limitOf(key)
+		if len(events) == 0 || !events[len(events)-1].After(now.Add(-limit.Period)) {
+			delete(c.windows, key)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(algorithm string, limit Limit) *MemoryLimiter {
+	return NewMemoryLimiter(LimiterOption{Algorithm: algorithm, Limit: limit}).(*MemoryLimiter)
+}
+
+func TestTokenBucket(t *testing.T) {
+	c := newTestLimiter(TokenBucket, Limit{Rate: 10, Period: time.Second, Burst: 5})
+	now := time.Now()
+	if ok, _ := c.reserve("k", 5, now); !ok {
+		t.Fatal("the burst was refused")
+	}
+	ok, delay := c.reserve("k", 1, now)
+	if ok || delay != 100*time.Millisecond {
+		t.Errorf("empty bucket = %v, %v, want false, 100ms", ok, delay)
+	}
+	if ok, _ := c.reserve("k", 1, now.Add(100*time.Millisecond)); !ok {
+		t.Error("a refilled token was refused")
+	}
+	// the bucket never holds more than the burst
+	if ok, _ := c.reserve("k", 5, now.Add(time.Hour)); !ok {
+		t.Error("a full bucket refused the burst")
+	}
+	if ok, _ := c.reserve("k", 1, now.Add(time.Hour)); ok {
+		t.Error("the bucket grew beyond the burst")
+	}
+	if ok, delay := c.reserve("k", 6, now); ok || delay >= 0 {
+		t.Errorf("n above the burst = %v, %v, want never", ok, delay)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	c := newTestLimiter(SlidingWindow, Limit{Rate: 3, Period: time.Second})
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if ok, _ := c.reserve("k", 1, now.Add(time.Duration(i)*100*time.Millisecond)); !ok {
+			t.Fatalf("event %d was refused", i)
+		}
+	}
+	ok, delay := c.reserve("k", 2, now.Add(500*time.Millisecond))
+	if ok || delay != 600*time.Millisecond {
+		t.Errorf("full window = %v, %v, want false, 600ms", ok, delay)
+	}
+	// the first event left the window
+	if ok, _ := c.reserve("k", 1, now.Add(time.Second+time.Millisecond)); !ok {
+		t.Error("an event was refused after the oldest one left")
+	}
+	if ok, delay := c.reserve("k", 4, now); ok || delay >= 0 {
+		t.Errorf("n above the rate = %v, %v, want never", ok, delay)
+	}
+}
+
+func TestLimitOverrides(t *testing.T) {
+	c := NewMemoryLimiter(LimiterOption{
+		Limit:  Limit{Rate: 1},
+		Limits: map[string]Limit{"vip": {Rate: 3}, "banned": {}},
+	}).(*MemoryLimiter)
+	now := time.Now()
+	if ok, _ := c.reserve("vip", 3, now); !ok {
+		t.Error("the override was not applied")
+	}
+	if ok, _ := c.reserve("other", 2, now); ok {
+		t.Error("the default limit was exceeded")
+	}
+	if ok, delay := c.reserve("banned", 1, now); ok || delay >= 0 {
+		t.Errorf("zero rate = %v, %v, want never", ok, delay)
+	}
+}
+
+func TestMemoryLimiterSweep(t *testing.T) {
+	for _, algorithm := range []string{TokenBucket, SlidingWindow} {
+		c := newTestLimiter(algorithm, Limit{Rate: 2, Period: time.Second})
+		now := time.Now()
+		c.reserve("idle", 2, now)
+		c.reserve("busy", 1, now.Add(sweepInterval))
+		// the sweep runs before the state of busy is updated
+		c.reserve("busy", 1, now.Add(sweepInterval+time.Millisecond))
+		if n := len(c.buckets) + len(c.windows); n != 1 {
+			t.Errorf("%s: %d keys left, want 1", algorithm, n)
+		}
+		if ok, _ := c.reserve("idle", 2, now.Add(sweepInterval+time.Millisecond)); !ok {
+			t.Errorf("%s: a swept key was limited", algorithm)
+		}
+	}
+}
+
+func TestWait(t *testing.T) {
+	c := newTestLimiter(TokenBucket, Limit{Rate: 1, Period: 20 * time.Millisecond})
+	ctx := context.Background()
+	c.Allow(ctx, "k")
+	start := time.Now()
+	if err := c.Wait(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("Wait returned after %v, want about 20ms", elapsed)
+	}
+	deadline, cancel := context.WithTimeout(ctx, 5*time.Millisecond)
+	defer cancel()
+	if err := c.Wait(deadline, "k"); err != context.DeadlineExceeded {
+		t.Errorf("Wait past the deadline = %v, want DeadlineExceeded", err)
+	}
+	never := newTestLimiter(TokenBucket, Limit{})
+	if err := never.Wait(ctx, "k"); err != ErrExceedsLimit {
+		t.Errorf("Wait on a zero rate = %v, want ErrExceedsLimit", err)
+	}
+}
@@ -0,0 +1,245 @@
+package limiter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aivencs/kit/pkg/cache"
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+const (
+	defaultPrefix = "kit:limiter:"
+	TokenBucket   = "token-bucket"
+	SlidingWindow = "sliding-window"
+)
+
+var (
+	limiter Limiter
+	once    sync.Once
+
+	ErrExceedsLimit = errors.New("limiter: request exceeds the limit and can never be allowed")
+	ErrNoRedis      = errors.New("limiter: redis limiter needs a redis cache")
+
+	tokenBucketScript = redigo.NewScript(1, `
+redis.replicate_commands()
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+local allowed, retry = 0, 0
+if n > burst then
+	retry = -1
+elseif tokens >= n then
+	tokens = tokens - n
+	allowed = 1
+else
+	retry = math.ceil((n - tokens) / rate)
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate) + 1000)
+return {allowed, retry}`)
+
+	slidingWindowScript = redigo.NewScript(1, `
+redis.replicate_commands()
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+if n > limit then
+	return {0, -1}
+end
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+if count + n <= limit then
+	for i = 1, n do
+		redis.call("ZADD", KEYS[1], now, ARGV[4] .. ":" .. i)
+	end
+	redis.call("PEXPIRE", KEYS[1], window)
+	return {1, 0}
+end
+local idx = count + n - limit - 1
+local oldest = redis.call("ZRANGE", KEYS[1], idx, idx, "WITHSCORES")
+return {0, math.max(1, tonumber(oldest[2]) + window - now)}`)
+)
+
+// Limiter throttles events per key.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (bool, error)
+	AllowN(ctx context.Context, key string, n int) (bool, error)
+	Wait(ctx context.Context, key string) error
+}
+
+// Limit allows Rate events per Period. Burst bounds the token bucket and
+// defaults to Rate, the sliding window ignores it.
+type Limit struct {
+	Rate   int
+	Period time.Duration
+	Burst  int
+}
+
+type LimiterOption struct {
+	Algorithm string
+	Limit     Limit
+	// per key overrides of Limit
+	Limits map[string]Limit
+	Prefix string
+}
+
+type RedisLimiter struct {
+	Cache *cache.CacheBaseRedis
+	opt   LimiterOption
+}
+
+// InitLimiter builds the package limiter, redis reuses the pool of the
+// package cache which must be initialized first, with a redis backend.
+func InitLimiter(name string, opt LimiterOption) {
+	once.Do(func() {
+		switch name {
+		case "memory":
+			limiter = NewMemoryLimiter(opt)
+		default:
+			rc := cache.Redis()
+			if rc == nil {
+				log.Fatal(ErrNoRedis)
+			}
+			limiter = NewRedisLimiter(rc, opt)
+		}
+	})
+}
+
+// new limiter base redis
+func NewRedisLimiter(c *cache.CacheBaseRedis, opt LimiterOption) Limiter {
+	return &RedisLimiter{
+		Cache: c,
+		opt:   applyOption(opt),
+	}
+}
+
+func applyOption(opt LimiterOption) LimiterOption {
+	if opt.Algorithm == "" {
+		opt.Algorithm = TokenBucket
+	}
+	if opt.Prefix == "" {
+		opt.Prefix = defaultPrefix
+	}
+	return opt
+}
+
+// limitOf returns the limit of key with defaults applied.
+func (opt LimiterOption) limitOf(key string) Limit {
+	limit, ok := opt.Limits[key]
+	if !ok {
+		limit = opt.Limit
+	}
+	if limit.Period <= 0 {
+		limit.Period = time.Second
+	}
+	if limit.Burst <= 0 {
+		limit.Burst = limit.Rate
+	}
+	return limit
+}
+
+func (c *RedisLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	return c.AllowN(ctx, key, 1)
+}
+
+func (c *RedisLimiter) AllowN(ctx context.Context, key string, n int) (bool, error) {
+	ok, _, err := c.reserve(ctx, key, n)
+	return ok, err
+}
+
+func (c *RedisLimiter) Wait(ctx context.Context, key string) error {
+	return wait(ctx, func() (bool, time.Duration, error) {
+		return c.reserve(ctx, key, 1)
+	})
+}
+
+// reserve takes n events and reports how long to wait before retrying when
+// it could not, a negative wait means never.
+func (c *RedisLimiter) reserve(ctx context.Context, key string, n int) (bool, time.Duration, error) {
+	limit := c.opt.limitOf(key)
+	if limit.Rate <= 0 {
+		return false, -1, nil
+	}
+	if c.Cache == nil {
+		return false, 0, ErrNoRedis
+	}
+	name := c.Cache.Key(c.opt.Prefix + key)
+	r := c.Cache.Conn(ctx, name)
+	defer r.Close()
+	var res []int64
+	var err error
+	switch c.opt.Algorithm {
+	case SlidingWindow:
+		res, err = redigo.Int64s(slidingWindowScript.Do(r, name, limit.Period.Milliseconds(), limit.Rate, n, newToken()))
+	default:
+		rate := float64(limit.Rate) / float64(limit.Period.Milliseconds())
+		res, err = redigo.Int64s(tokenBucketScript.Do(r, name, strconv.FormatFloat(rate, 'g', -1, 64), limit.Burst, n))
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	if len(res) != 2 {
+		return false, 0, errors.New("limiter: unexpected script reply")
+	}
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
+
+// wait retries reserve until it succeeds or ctx is done.
+func wait(ctx context.Context, reserve func() (bool, time.Duration, error)) error {
+	for {
+		ok, delay, err := reserve()
+		if err != nil || ok {
+			return err
+		}
+		if delay < 0 {
+			return ErrExceedsLimit
+		}
+		if deadline, has := ctx.Deadline(); has && time.Until(deadline) < delay {
+			return context.DeadlineExceeded
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func newToken() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+/*
+for caller
+*/
+
+func Allow(ctx context.Context, key string) (bool, error) {
+	return limiter.Allow(ctx, key)
+}
+
+func AllowN(ctx context.Context, key string, n int) (bool, error) {
+	return limiter.AllowN(ctx, key, n)
+}
+
+func Wait(ctx context.Context, key string) error {
+	return limiter.Wait(ctx, key)
+}
@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds of the latency histograms, in seconds.
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// Collector receives the measurements of the instrumented packages, such as
+// cache and filter which report as their component.
+type Collector interface {
+	// ObserveOp records one operation and its latency, err is nil on success.
+	ObserveOp(component, op string, d time.Duration, err error)
+	// ObserveLookup counts the keys an operation found and missed.
+	ObserveLookup(component, op string, hits, misses int)
+	// RegisterPool adds a source of connection pool stats, read whenever
+	// the collector is scraped.
+	RegisterPool(component string, stats func() PoolStats)
+}
+
+type PoolStats struct {
+	Active       int
+	Idle         int
+	WaitCount    int64
+	WaitDuration time.Duration
+}
+
+// Registry is a Collector keeping the measurements in memory.
+type Registry struct {
+	mu      sync.Mutex
+	buckets []float64
+	ops     map[opKey]*opStats
+	pools   map[string]func() PoolStats
+}
+
+type opKey struct {
+	Component string
+	Op        string
+}
+
+type opStats struct {
+	calls   uint64
+	errors  uint64
+	hits    uint64
+	misses  uint64
+	sum     float64
+	buckets []uint64
+}
+
+// OpSnapshot holds the counters of one operation. Buckets are cumulative and
+// follow the bounds of the registry.
+type OpSnapshot struct {
+	Component string
+	Op        string
+	Calls     uint64
+	Errors    uint64
+	Hits      uint64
+	Misses    uint64
+	Sum       float64
+	Buckets   []uint64
+}
+
+type PoolSnapshot struct {
+	Component string
+	PoolStats
+}
+
+// new registry, latency histograms use buckets or DefaultBuckets when empty
+func NewRegistry(buckets ...float64) *Registry {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Registry{
+		buckets: buckets,
+		ops:     make(map[opKey]*opStats),
+		pools:   make(map[string]func() PoolStats),
+	}
+}
+
+func (r *Registry) stats(component, op string) *opStats {
+	key := opKey{Component: component, Op: op}
+	s, ok := r.ops[key]
+	if !ok {
+		s = &opStats{buckets: make([]uint64, len(r.buckets))}
+		r.ops[key] = s
+	}
+	return s
+}
+
+func (r *Registry) ObserveOp(component, op string, d time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.stats(component, op)
+	s.calls++
+	if err != nil {
+		s.errors++
+	}
+	seconds := d.Seconds()
+	s.sum += seconds
+	for i, bound := range r.buckets {
+		if seconds <= bound {
+			s.buckets[i]++
+		}
+	}
+}
+
+func (r *Registry) ObserveLookup(component, op string, hits, misses int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.stats(component, op)
+	s.hits += uint64(hits)
+	s.misses += uint64(misses)
+}
+
+// RegisterPool replaces any earlier source of component.
+func (r *Registry) RegisterPool(component string, stats func() PoolStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pools[component] = stats
+}
+
+func (r *Registry) Buckets() []float64 {
+	return append([]float64(nil), r.buckets...)
+}
+
+// Ops returns the operation counters sorted by component and op.
+func (r *Registry) Ops() []OpSnapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ops := make([]OpSnapshot, 0, len(r.ops))
+	for key, s := range r.ops {
+		ops = append(ops, OpSnapshot{
+			Component: key.Component,
+			Op:        key.Op,
+			Calls:     s.calls,
+			Errors:    s.errors,
+			Hits:      s.hits,
+			Misses:    s.misses,
+			Sum:       s.sum,
+			Buckets:   append([]uint64(nil), s.buckets...),
+		})
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].Component != ops[j].Component {
+			return ops[i].Component < ops[j].Component
+		}
+		return ops[i].Op < ops[j].Op
+	})
+	return ops
+}
+
+// Pools reads every registered pool, sorted by component.
+func (r *Registry) Pools() []PoolSnapshot {
+	r.mu.Lock()
+	sources := make(map[string]func() PoolStats, len(r.pools))
+	for component, stats := range r.pools {
+		sources[component] = stats
+	}
+	r.mu.Unlock()
+	pools := make([]PoolSnapshot, 0, len(sources))
+	for component, stats := range sources {
+		pools = append(pools, PoolSnapshot{Component: component, PoolStats: stats()})
+	}
+	sort.Slice(pools, func(i, j int) bool {
+		return pools[i].Component < pools[j].Component
+	})
+	return pools
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRegistryOps(t *testing.T) {
+	r := NewRegistry(1, 0.1)
+	r.ObserveOp("cache", "get", 50*time.Millisecond, nil)
+	r.ObserveOp("cache", "get", 500*time.Millisecond, errors.New("down"))
+	r.ObserveOp("cache", "get", 2*time.Second, nil)
+	r.ObserveLookup("cache", "get", 1, 2)
+	r.ObserveOp("cache", "del", time.Millisecond, nil)
+	r.ObserveLookup("filter", "exist", 3, 0)
+	want := []OpSnapshot{
+		{Component: "cache", Op: "del", Calls: 1, Sum: 0.001, Buckets: []uint64{1, 1}},
+		{Component: "cache", Op: "get", Calls: 3, Errors: 1, Hits: 1, Misses: 2, Sum: 2.55, Buckets: []uint64{1, 2}},
+		// a lookup alone counts no call
+		{Component: "filter", Op: "exist", Hits: 3, Buckets: []uint64{0, 0}},
+	}
+	if got := r.Ops(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Ops = %+v, want %+v", got, want)
+	}
+	if got := r.Buckets(); !reflect.DeepEqual(got, []float64{0.1, 1}) {
+		t.Errorf("Buckets = %v, want sorted bounds", got)
+	}
+	// snapshots are copies
+	r.Ops()[0].Buckets[0] = 9
+	if r.Ops()[0].Buckets[0] != 1 {
+		t.Error("a snapshot shares the buckets of the registry")
+	}
+}
+
+func TestRegistryDefaultBuckets(t *testing.T) {
+	if got := NewRegistry().Buckets(); !reflect.DeepEqual(got, DefaultBuckets) {
+		t.Errorf("Buckets = %v, want DefaultBuckets", got)
+	}
+}
+
+func TestRegistryPools(t *testing.T) {
+	r := NewRegistry()
+	reads := 0
+	r.RegisterPool("filter", func() PoolStats {
+		reads++
+		return PoolStats{Active: reads}
+	})
+	r.RegisterPool("cache", func() PoolStats { return PoolStats{Active: 1} })
+	// a later source replaces the earlier one
+	r.RegisterPool("cache", func() PoolStats { return PoolStats{Active: 3, Idle: 2, WaitCount: 1, WaitDuration: time.Second} })
+	r.Pools()
+	want := []PoolSnapshot{
+		{Component: "cache", PoolStats: PoolStats{Active: 3, Idle: 2, WaitCount: 1, WaitDuration: time.Second}},
+		{Component: "filter", PoolStats: PoolStats{Active: 2}},
+	}
+	if got := r.Pools(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Pools = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// WritePrometheus writes the measurements of r in the prometheus text
+// exposition format.
+func WritePrometheus(w io.Writer, r *Registry) error {
+	b := bufio.NewWriter(w)
+	ops := r.Ops()
+	buckets := r.Buckets()
+
+	header(b, "kit_operations_total", "counter", "Operations performed.")
+	for _, op := range ops {
+		fmt.Fprintf(b, "kit_operations_total{%s} %d\n", opLabels(op), op.Calls)
+	}
+	header(b, "kit_operation_errors_total", "counter", "Operations that failed.")
+	for _, op := range ops {
+		fmt.Fprintf(b, "kit_operation_errors_total{%s} %d\n", opLabels(op), op.Errors)
+	}
+	header(b, "kit_lookup_hits_total", "counter", "Keys found by lookups.")
+	for _, op := range ops {
+		if op.Hits+op.Misses > 0 {
+			fmt.Fprintf(b, "kit_lookup_hits_total{%s} %d\n", opLabels(op), op.Hits)
+		}
+	}
+	header(b, "kit_lookup_misses_total", "counter", "Keys missed by lookups.")
+	for _, op := range ops {
+		if op.Hits+op.Misses > 0 {
+			fmt.Fprintf(b, "kit_lookup_misses_total{%s} %d\n", opLabels(op), op.Misses)
+		}
+	}
+	header(b, "kit_operation_duration_seconds", "histogram", "Latency of operations.")
+	for _, op := range ops {
+		labels := opLabels(op)
+		for i, bound := range buckets {
+			fmt.Fprintf(b, "kit_operation_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(bound), op.Buckets[i])
+		}
+		fmt.Fprintf(b, "kit_operation_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, op.Calls)
+		fmt.Fprintf(b, "kit_operation_duration_seconds_sum{%s} %s\n", labels, formatFloat(op.Sum))
+		fmt.Fprintf(b, "kit_operation_duration_seconds_count{%s} %d\n", labels, op.Calls)
+	}
+
+	pools := r.Pools()
+	header(b, "kit_pool_active_connections", "gauge", "Connections in use or idle in the pool.")
+	for _, p := range pools {
+		fmt.Fprintf(b, "kit_pool_active_connections{component=\"%s\"} %d\n", escape(p.Component), p.Active)
+	}
+	header(b, "kit_pool_idle_connections", "gauge", "Idle connections in the pool.")
+	for _, p := range pools {
+		fmt.Fprintf(b, "kit_pool_idle_connections{component=\"%s\"} %d\n", escape(p.Component), p.Idle)
+	}
+	header(b, "kit_pool_waits_total", "counter", "Borrows that waited for a connection.")
+	for _, p := range pools {
+		fmt.Fprintf(b, "kit_pool_waits_total{component=\"%s\"} %d\n", escape(p.Component), p.WaitCount)
+	}
+	header(b, "kit_pool_wait_seconds_total", "counter", "Time spent waiting for a connection.")
+	for _, p := range pools {
+		fmt.Fprintf(b, "kit_pool_wait_seconds_total{component=\"%s\"} %s\n", escape(p.Component), formatFloat(p.WaitDuration.Seconds()))
+	}
+	return b.Flush()
+}
+
+// PrometheusHandler serves r for a prometheus scraper.
+func PrometheusHandler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		WritePrometheus(w, r)
+	})
+}
+
+func header(b *bufio.Writer, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func opLabels(op OpSnapshot) string {
+	return fmt.Sprintf(`component="%s",op="%s"`, escape(op.Component), escape(op.Op))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	r := NewRegistry(0.01, 0.1)
+	r.ObserveOp("cache", "get", 5*time.Millisecond, nil)
+	r.ObserveOp("cache", "get", 50*time.Millisecond, errors.New("down"))
+	r.ObserveLookup("cache", "get", 1, 1)
+	r.ObserveOp("cache", "set", 5*time.Millisecond, nil)
+	r.ObserveOp(`a"b`, "op\n", time.Second, nil)
+	r.RegisterPool("cache", func() PoolStats {
+		return PoolStats{Active: 3, Idle: 1, WaitCount: 2, WaitDuration: 1500 * time.Millisecond}
+	})
+	var b bytes.Buffer
+	if err := WritePrometheus(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, line := range []string{
+		"# TYPE kit_operations_total counter",
+		`kit_operations_total{component="cache",op="get"} 2`,
+		`kit_operation_errors_total{component="cache",op="get"} 1`,
+		`kit_lookup_hits_total{component="cache",op="get"} 1`,
+		`kit_lookup_misses_total{component="cache",op="get"} 1`,
+		"# TYPE kit_operation_duration_seconds histogram",
+		`kit_operation_duration_seconds_bucket{component="cache",op="get",le="0.01"} 1`,
+		`kit_operation_duration_seconds_bucket{component="cache",op="get",le="0.1"} 2`,
+		`kit_operation_duration_seconds_bucket{component="cache",op="get",le="+Inf"} 2`,
+		`kit_operation_duration_seconds_sum{component="cache",op="get"} 0.055`,
+		`kit_operation_duration_seconds_count{component="cache",op="get"} 2`,
+		`kit_operations_total{component="a\"b",op="op\n"} 1`,
+		`kit_pool_active_connections{component="cache"} 3`,
+		`kit_pool_idle_connections{component="cache"} 1`,
+		`kit_pool_waits_total{component="cache"} 2`,
+		`kit_pool_wait_seconds_total{component="cache"} 1.5`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, out)
+		}
+	}
+	// operations without lookups report no hits or misses
+	if strings.Contains(out, `kit_lookup_hits_total{component="cache",op="set"}`) {
+		t.Error("set reported lookup hits")
+	}
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	r := NewRegistry()
+	r.ObserveOp("filter", "add", time.Millisecond, nil)
+	w := httptest.NewRecorder()
+	PrometheusHandler(r).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != contentType {
+		t.Errorf("Content-Type = %q, want %q", ct, contentType)
+	}
+	if !strings.Contains(w.Body.String(), `kit_operations_total{component="filter",op="add"} 1`) {
+		t.Errorf("body misses the operation:\n%s", w.Body)
+	}
+}